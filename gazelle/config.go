@@ -19,8 +19,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
@@ -46,6 +52,257 @@ type JsConfig struct {
 
 	// GenerateTests decides if jest_node_test rules will be generated or not.
 	GenerateTests bool
+
+	// TsPaths are the "paths" mappings of the closest tsconfig.json that defines any.
+	TsPaths tsPathMappings
+
+	// TsBaseURL is the "baseUrl" of the closest tsconfig.json that defines one, relative
+	// to the repository root, or "" if there is none.
+	TsBaseURL string
+
+	// TsRootDirs are the "rootDirs" of the closest tsconfig.json that defines any,
+	// relative to the repository root.
+	TsRootDirs []string
+
+	// BabelRuntime is the label of the babel runtime helpers package, which
+	// @babel/plugin-transform-runtime injects imports of at build time. When set it
+	// is added as a dependency to every generated library.
+	BabelRuntime string
+
+	// JestRuntimeDeps are the labels of the packages the jest runtime needs, like jest
+	// and ts-jest, which are added as dependencies to every generated jest_test.
+	JestRuntimeDeps []string
+
+	// TestAutoLibDep makes every generated test depend on the library of the same
+	// name in its package, e.g. foo.test.js on foo, even if it does not import it.
+	TestAutoLibDep bool
+
+	// SharedFilegroup hoists the sources of each extension in a directory into an all_<ext>
+	// filegroup, which rules with exactly these sources refer to instead of listing them.
+	SharedFilegroup bool
+
+	// TsProjectMode decides if a ts_project is generated for every ts file or, in directory
+	// mode, one for all ts files and declarations of a directory.
+	TsProjectMode string
+
+	// ScopeDirs maps npm scopes of first-party packages to the directory containing
+	// them, e.g. @acme to packages for @acme/ui living in packages/ui.
+	ScopeDirs map[string]string
+
+	// TypeOnlyDeps decides if type-only imports become deps. If it is not set, they do
+	// for TypeScript rules, which need them for type checking, but not for js libraries.
+	TypeOnlyDeps *bool
+
+	// TsConfigTarget is the tsconfig used by all ts_project rules. If it is not set,
+	// they use the closest tsconfig.json.
+	TsConfigTarget label.Label
+
+	// EnforceFiles drops imports of files of first-party packages from outside of them
+	// that are not published by the files allowlist of their package.json.
+	EnforceFiles bool
+
+	// IndexAliases indexes alias rules by their name, so imports can resolve to them.
+	IndexAliases bool
+
+	// DetectAssetAssignments adds the assets whose relative paths are assigned to src or
+	// href properties, like img.src = './sprite.png', to the data of a rule.
+	DetectAssetAssignments bool
+
+	// ExtensionlessFiles are the files without an extension that get rules, either as
+	// js sources or as assets, keyed by their name. Other such files are skipped.
+	ExtensionlessFiles map[string]string
+
+	// DepsAttr is the attribute the resolved dependencies are written to, for macros
+	// calling it something else than deps.
+	DepsAttr string
+
+	// TypesTarget is the name of the declaration sub-target of a ts_project, with {name}
+	// standing for the name of the ts_project, which type-only imports resolve to.
+	TypesTarget string
+
+	// PackageJSONTarget generates a package_json filegroup for the package.json of each
+	// directory, which imports of the package.json resolve to.
+	PackageJSONTarget bool
+
+	// InlineLoaderDeps adds the loaders of webpack inline loader imports, like
+	// worker-loader in worker-loader!./worker.js, as npm dependencies.
+	InlineLoaderDeps bool
+
+	// NextJs resolves the static imports of images of Next.js apps, like
+	// import logo from '../public/logo.png' for next/image, to the files as data.
+	NextJs bool
+
+	// CSSInJs adds the files referenced with url() in styled-components and emotion
+	// templates, like the fonts of a createGlobalStyle, to the data of a rule.
+	CSSInJs bool
+
+	// TsValidate sets the validate attribute of generated ts_project rules. If it is
+	// not set, the attribute is omitted and the default of the macro applies.
+	TsValidate *bool
+
+	// MdxLibrary generates an mdx_library for every MDX document, which is the default.
+	// Its kind is set with map_kind.
+	MdxLibrary bool
+
+	// CypressTest generates a cypress_test for every Cypress spec like login.cy.ts,
+	// instead of treating them as libraries. Its kind is set with map_kind.
+	CypressTest bool
+
+	// PersistImports records the imports of each rule and the labels they resolved to,
+	// or "" if they did not resolve to a dependency, in a js_imports dict for tooling.
+	PersistImports bool
+
+	// GoldenDir is the name of the directories next to tests holding the golden files
+	// they compare against, which become data of all tests of the package.
+	GoldenDir string
+
+	// MigrationPrefer is the language, js or ts, an import resolves to when a file exists
+	// in both during a migration, e.g. foo.ts over foo.js for ./foo.
+	MigrationPrefer string
+
+	// StrictResolution makes imports that can't be resolved fail the run, except for the
+	// ones matching AllowUnresolved.
+	StrictResolution bool
+	AllowUnresolved  []string
+
+	// CrossLangImports are the imports resolved to rules of other languages, like the
+	// wasm modules of Rust rules, with the innermost directives first. They name the
+	// language rather than the kind of the rules, as the index of gazelle is keyed by
+	// language and doesn't record the kinds of the rules it finds.
+	CrossLangImports []crossLangImport
+
+	// OpaqueExtensions are the extensions of files like bundles whose imports are not
+	// extracted. They still get their rules but no dependencies.
+	OpaqueExtensions []string
+
+	// PlatformExtensions are the platforms of React Native style variants like
+	// Button.ios.js, which are grouped into one rule imported as ./Button.
+	PlatformExtensions []string
+
+	// tsConfigDir is the directory of the closest tsconfig.json, if any.
+	tsConfigDir *string
+
+	// NpmDepsReport is the path of the JSON report of the npm packages each Bazel package
+	// depends on, relative to the repository root. No report is written if it is empty.
+	NpmDepsReport string
+
+	// npmDeps collects the report written to NpmDepsReport, shared by all directories.
+	npmDeps *npmDepsReport
+
+	// pendingRules counts the generated rules yet to be resolved, shared by all directories.
+	// Gazelle generates the rules of every directory before it resolves any, but doesn't
+	// tell languages when it is done, so resolving is over once the count drops to zero.
+	pendingRules *int
+
+	// unresolved collects the imports failing the run with js_strict_resolution, shared by
+	// all directories, which are reported together once resolving is over.
+	unresolved *[]error
+
+	// pnp holds the dependencies of a yarn Plug'n'Play install if js_pnp is enabled.
+	pnp *pnpData
+
+	// packages holds the first-party packages found so far, shared by all directories.
+	packages *packageRegistry
+
+	// aliases maps the alias rules indexed with js_index_aliases to their actual targets,
+	// shared by all directories.
+	aliases map[label.Label]label.Label
+
+	// mappedImports indexes the rules whose kind was changed with map_kind, shared by all
+	// directories. Gazelle 0.17 can't find the language of a mapped rule in its own index,
+	// so these rules are kept out of it and looked up here instead.
+	mappedImports map[resolve.ImportSpec][]resolve.FindResult
+}
+
+func (js *JsConfig) clone() *JsConfig {
+	clone := *js
+	return &clone
+}
+
+// crossLangImport resolves the imports matching Pattern, which is matched against the
+// file name if it has no slash and against the repo-relative import otherwise, to the
+// rules of the language Lang.
+type crossLangImport struct {
+	Pattern, Lang string
+}
+
+// crossLangImport returns the language of the rules the normalised import imp resolves
+// to according to the js_cross_lang_import directives.
+func (js *JsConfig) crossLangImport(imp string) (string, bool) {
+	for _, cross := range js.CrossLangImports {
+		name := imp
+		if !strings.Contains(cross.Pattern, "/") {
+			name = path.Base(imp)
+		}
+		if ok, _ := path.Match(cross.Pattern, name); ok {
+			return cross.Lang, true
+		}
+	}
+	return "", false
+}
+
+// aliasActual returns the target the alias l forwards to, following chains of aliases,
+// or l itself if it is not an alias indexed with js_index_aliases.
+func (js *JsConfig) aliasActual(l label.Label) label.Label {
+	for i := 0; i < len(js.aliases); i++ {
+		actual, ok := js.aliases[l]
+		if !ok {
+			break
+		}
+		l = actual
+	}
+	return l
+}
+
+// unresolvedImport returns the error failing the run for the import imp of from that
+// could not be resolved when js_strict_resolution is enabled, unless imp is allowed by
+// js_allow_unresolved.
+func (js *JsConfig) unresolvedImport(imp string, from label.Label) error {
+	if !js.StrictResolution {
+		return nil
+	}
+	for _, pattern := range js.AllowUnresolved {
+		if ok, _ := path.Match(pattern, imp); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("import %v for %s could not be resolved, allow it with # gazelle:js_allow_unresolved", imp, from.Abs(from.Repo, from.Pkg))
+}
+
+// addUnresolved records the error of an import failing the run once resolving is over.
+func (js *JsConfig) addUnresolved(err error) {
+	if js.unresolved != nil {
+		*js.unresolved = append(*js.unresolved, err)
+	}
+}
+
+// The ways files without an extension are handled with js_extensionless_files.
+const (
+	extensionlessSource = "source"
+	extensionlessAsset  = "asset"
+)
+
+// depsAttr returns the attribute the resolved dependencies are written to.
+func (js *JsConfig) depsAttr() string {
+	if js.DepsAttr == "" {
+		return "deps"
+	}
+	return js.DepsAttr
+}
+
+// typesTarget returns the name of the declaration sub-target of the ts_project name.
+func (js *JsConfig) typesTarget(name string) string {
+	return strings.Replace(js.TypesTarget, "{name}", name, -1)
+}
+
+// ruleKind returns the kind r was generated as, undoing any map_kind directive.
+func ruleKind(c *config.Config, r *rule.Rule) string {
+	for _, mapped := range c.KindMap {
+		if mapped.KindName == r.Kind() {
+			return mapped.FromKind
+		}
+	}
+	return r.Kind()
 }
 
 // GetJsConfig returns the js language configuration. If the js
@@ -58,6 +315,13 @@ func GetJsConfig(c *config.Config) *JsConfig {
 	return js.(*JsConfig)
 }
 
+const (
+	// TsProjectFileMode generates a ts_project for every ts file, which is the default.
+	TsProjectFileMode = "file"
+	// TsProjectDirectoryMode generates a single ts_project for each directory.
+	TsProjectDirectoryMode = "directory"
+)
+
 type Library int
 
 const (
@@ -96,7 +360,7 @@ func (lib Library) String() string {
 // starts. RegisterFlags may set an initial values in Config.Exts. When flags
 // are set, they should modify these values.
 func (s *jslang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
-	js := &JsConfig{}
+	js := &JsConfig{TsProjectMode: TsProjectFileMode, MdxLibrary: true, packages: newPackageRegistry(), aliases: make(map[label.Label]label.Label), mappedImports: make(map[resolve.ImportSpec][]resolve.FindResult), pendingRules: new(int), unresolved: new([]error)}
 	c.Exts[extName] = js
 
 	fs.Var(&libraryFlag{&js.JsLibrary}, "js_library", "js_library: Uses js_library\n\tbabel_library: Uses babel_library")
@@ -104,12 +368,25 @@ func (s *jslang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
 	fs.StringVar(&js.NpmWorkspaceName, "npm_workspace_name", "npm", "option to change the name of the external workspace where npm/yarn is installing its packages to")
 	fs.BoolVar(&js.AliasImportSupport, "alias_import_support", false, "Enables or disables alias import support, such as imports starting with ~, etc.")
 	fs.BoolVar(&js.GenerateTests, "generate_js_tests", false, "Enables or disables generation of jest_node_test rules for .test.js files.")
+	fs.StringVar(&js.NpmDepsReport, "js_npm_deps_report", "", "Writes a JSON report of the npm packages the generated rules of each package depend on to this path.")
 }
 
 // CheckFlags validates the configuration after command line flags are parsed.
 // This is called once with the root configuration when Gazelle starts.
 // CheckFlags may set default values in flags or make implied changes.
 func (s *jslang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	js := GetJsConfig(c)
+	if js.NpmDepsReport != "" {
+		reportPath := js.NpmDepsReport
+		if !filepath.IsAbs(reportPath) {
+			reportPath = filepath.Join(c.RepoRoot, reportPath)
+		}
+		report, err := newNpmDepsReport(reportPath)
+		if err != nil {
+			return err
+		}
+		js.npmDeps = report
+	}
 	return nil
 }
 
@@ -117,7 +394,41 @@ func (s *jslang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
 // interpret. Gazelle prints errors for directives that are not recoginized by
 // any Configurer.
 func (s *jslang) KnownDirectives() []string {
-	return []string{"js_library", "ts_project", "jest_test"}
+	return []string{
+		"js_library",
+		"ts_project",
+		"jest_test",
+		"js_babel_runtime",
+		"js_test_auto_lib_dep",
+		"js_shared_filegroup",
+		"js_ts_project_mode",
+		"js_scope_dir",
+		"js_type_only_deps",
+		"js_ts_config_target",
+		"js_mdx_library",
+		"js_platform_extensions",
+		"js_pnp",
+		"js_migration_prefer",
+		"js_golden_dir",
+		"js_persist_imports",
+		"js_cypress_test",
+		"js_jest_runtime_deps",
+		"js_ts_validate",
+		"js_detect_asset_assignments",
+		"js_index_aliases",
+		"js_enforce_files",
+		"js_css_in_js",
+		"js_nextjs",
+		"js_inline_loader_deps",
+		"js_package_json_target",
+		"js_deps_attr",
+		"js_types_target",
+		"js_extensionless_files",
+		"js_opaque_extensions",
+		"js_cross_lang_import",
+		"js_strict_resolution",
+		"js_allow_unresolved",
+	}
 }
 
 // Configure modifies the configuration using directives and other information
@@ -132,4 +443,225 @@ func (s *jslang) KnownDirectives() []string {
 // f is the build file for the current directory or nil if there is no
 // existing build file.
 func (s *jslang) Configure(c *config.Config, rel string, f *rule.File) {
+	var js *JsConfig
+	if raw, ok := c.Exts[extName]; !ok {
+		js = &JsConfig{}
+	} else {
+		js = raw.(*JsConfig).clone()
+	}
+	c.Exts[extName] = js
+
+	tsconfig, err := loadTsConfig(filepath.Join(c.RepoRoot, rel), rel)
+	if err != nil {
+		log.Print(err)
+	} else if tsconfig != nil {
+		js.tsConfigDir = &rel
+		if paths := tsconfig.pathMappings(rel); paths != nil {
+			js.TsPaths = paths
+		}
+		if baseURL := tsconfig.CompilerOptions.BaseURL; baseURL != "" {
+			js.TsBaseURL = path.Join(rel, baseURL)
+		}
+		if rootDirs := tsconfig.rootDirs(rel); rootDirs != nil {
+			js.TsRootDirs = rootDirs
+		}
+		if outDir := tsconfig.CompilerOptions.OutDir; outDir != "" && js.packages != nil {
+			js.packages.addOutDir(path.Join(rel, outDir), path.Join(rel, tsconfig.CompilerOptions.RootDir))
+		}
+	}
+
+	if pkg, err := loadPackageJSON(filepath.Join(c.RepoRoot, rel), rel); err != nil {
+		log.Print(err)
+	} else if pkg != nil && js.packages != nil {
+		js.packages.add(pkg)
+	}
+
+	if f == nil {
+		return
+	}
+	for _, d := range f.Directives {
+		switch d.Key {
+		case "js_babel_runtime":
+			js.BabelRuntime = d.Value
+		case "js_jest_runtime_deps":
+			js.JestRuntimeDeps = nil
+			for _, dep := range strings.Split(d.Value, ",") {
+				if dep = strings.TrimSpace(dep); dep != "" {
+					js.JestRuntimeDeps = append(js.JestRuntimeDeps, dep)
+				}
+			}
+		case "js_test_auto_lib_dep":
+			parseBoolDirective(rel, d, &js.TestAutoLibDep)
+		case "js_shared_filegroup":
+			parseBoolDirective(rel, d, &js.SharedFilegroup)
+		case "js_ts_project_mode":
+			switch d.Value {
+			case TsProjectFileMode, TsProjectDirectoryMode:
+				js.TsProjectMode = d.Value
+			default:
+				log.Printf("%s: invalid value for js_ts_project_mode: %q, must be %q or %q", rel, d.Value, TsProjectFileMode, TsProjectDirectoryMode)
+			}
+		case "js_scope_dir":
+			kv := strings.SplitN(d.Value, "=", 2)
+			if len(kv) != 2 || !strings.HasPrefix(kv[0], "@") {
+				log.Printf("%s: invalid value for js_scope_dir: %q, must be @scope=dir", rel, d.Value)
+				continue
+			}
+			// The map is shared with the parent directory's config
+			scopeDirs := make(map[string]string)
+			for scope, dir := range js.ScopeDirs {
+				scopeDirs[scope] = dir
+			}
+			scopeDirs[kv[0]] = path.Clean(kv[1])
+			js.ScopeDirs = scopeDirs
+		case "js_type_only_deps":
+			var typeOnlyDeps bool
+			if parseBoolDirective(rel, d, &typeOnlyDeps) {
+				js.TypeOnlyDeps = &typeOnlyDeps
+			}
+		case "js_enforce_files":
+			parseBoolDirective(rel, d, &js.EnforceFiles)
+		case "js_index_aliases":
+			parseBoolDirective(rel, d, &js.IndexAliases)
+		case "js_detect_asset_assignments":
+			parseBoolDirective(rel, d, &js.DetectAssetAssignments)
+		case "js_extensionless_files":
+			extensionless := make(map[string]string)
+			for name, as := range js.ExtensionlessFiles {
+				extensionless[name] = as
+			}
+			for _, entry := range strings.Split(d.Value, ",") {
+				if entry = strings.TrimSpace(entry); entry == "" {
+					continue
+				}
+				kv := strings.SplitN(entry, "=", 2)
+				if len(kv) != 2 || (kv[1] != extensionlessSource && kv[1] != extensionlessAsset) {
+					log.Printf("%s: invalid value for js_extensionless_files: %q, must be name=source or name=asset", rel, entry)
+					continue
+				}
+				extensionless[kv[0]] = kv[1]
+			}
+			js.ExtensionlessFiles = extensionless
+		case "js_deps_attr":
+			if rel != "" {
+				// The attribute maps gazelle merges with are shared by all packages
+				log.Printf("%s: js_deps_attr is only supported in the root BUILD file", rel)
+			} else if js.DepsAttr = strings.TrimSpace(d.Value); js.DepsAttr != "" {
+				s.addDepsAttr(js.DepsAttr)
+			}
+		case "js_types_target":
+			js.TypesTarget = strings.TrimSpace(d.Value)
+		case "js_package_json_target":
+			parseBoolDirective(rel, d, &js.PackageJSONTarget)
+		case "js_inline_loader_deps":
+			parseBoolDirective(rel, d, &js.InlineLoaderDeps)
+		case "js_nextjs":
+			parseBoolDirective(rel, d, &js.NextJs)
+		case "js_css_in_js":
+			parseBoolDirective(rel, d, &js.CSSInJs)
+		case "js_ts_validate":
+			var validate bool
+			if parseBoolDirective(rel, d, &validate) {
+				js.TsValidate = &validate
+			}
+		case "js_strict_resolution":
+			parseBoolDirective(rel, d, &js.StrictResolution)
+		case "js_allow_unresolved":
+			allowed := append([]string{}, js.AllowUnresolved...)
+			for _, pattern := range strings.Split(d.Value, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern == "" {
+					continue
+				}
+				if _, err := path.Match(pattern, ""); err != nil {
+					log.Printf("%s: invalid pattern for js_allow_unresolved: %q: %v", rel, pattern, err)
+					continue
+				}
+				allowed = append(allowed, pattern)
+			}
+			js.AllowUnresolved = allowed
+		case "js_cross_lang_import":
+			kv := strings.SplitN(d.Value, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+				log.Printf("%s: invalid value for js_cross_lang_import: %q, must be pattern=language", rel, d.Value)
+				continue
+			}
+			cross := crossLangImport{Pattern: strings.TrimSpace(kv[0]), Lang: strings.TrimSpace(kv[1])}
+			if _, err := path.Match(cross.Pattern, ""); err != nil {
+				log.Printf("%s: invalid pattern for js_cross_lang_import: %q: %v", rel, cross.Pattern, err)
+				continue
+			}
+			js.CrossLangImports = append([]crossLangImport{cross}, js.CrossLangImports...)
+		case "js_opaque_extensions":
+			js.OpaqueExtensions = nil
+			for _, ext := range strings.Split(d.Value, ",") {
+				if ext = strings.TrimSpace(ext); ext != "" {
+					js.OpaqueExtensions = append(js.OpaqueExtensions, ext)
+				}
+			}
+		case "js_platform_extensions":
+			js.PlatformExtensions = nil
+			for _, platform := range strings.Split(d.Value, ",") {
+				if platform = strings.TrimPrefix(strings.TrimSpace(platform), "."); platform != "" {
+					js.PlatformExtensions = append(js.PlatformExtensions, platform)
+				}
+			}
+		case "js_mdx_library":
+			parseBoolDirective(rel, d, &js.MdxLibrary)
+		case "js_cypress_test":
+			parseBoolDirective(rel, d, &js.CypressTest)
+		case "js_persist_imports":
+			parseBoolDirective(rel, d, &js.PersistImports)
+		case "js_golden_dir":
+			js.GoldenDir = strings.Trim(d.Value, "/")
+		case "js_migration_prefer":
+			switch d.Value {
+			case "js", "ts", "":
+				js.MigrationPrefer = d.Value
+			default:
+				log.Printf("%s: invalid value for js_migration_prefer: %q, must be \"js\" or \"ts\"", rel, d.Value)
+			}
+		case "js_pnp":
+			var enabled bool
+			if !parseBoolDirective(rel, d, &enabled) {
+				continue
+			}
+			js.pnp = nil
+			if enabled {
+				if pnp, err := loadPnpData(c.RepoRoot); err != nil {
+					log.Printf("%s: js_pnp: %v", rel, err)
+				} else {
+					js.pnp = pnp
+				}
+			}
+		case "js_ts_config_target":
+			if l, err := label.Parse(d.Value); err != nil {
+				log.Printf("%s: invalid value for js_ts_config_target: %v", rel, err)
+			} else {
+				js.TsConfigTarget = l.Abs("", rel)
+			}
+		}
+	}
+}
+
+// tsConfigLabel returns the tsconfig for ts_project rules, or label.NoLabel if there is none.
+func (js *JsConfig) tsConfigLabel() label.Label {
+	if js.TsConfigTarget != label.NoLabel {
+		return js.TsConfigTarget
+	}
+	if js.tsConfigDir != nil {
+		return label.New("", *js.tsConfigDir, "tsconfig.json")
+	}
+	return label.NoLabel
+}
+
+// parseBoolDirective sets v to the boolean value of directive d. Invalid values are
+// logged and leave v untouched, in which case false is returned.
+func parseBoolDirective(rel string, d rule.Directive, v *bool) bool {
+	b, err := strconv.ParseBool(d.Value)
+	if err != nil {
+		log.Printf("%s: invalid value for %s: %v", rel, d.Key, err)
+		return false
+	}
+	*v = b
+	return true
 }
@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"io/ioutil"
 	"log"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -31,10 +33,441 @@ type FileInfo struct {
 	Path, Name string
 
 	Imports []string
+
+	// TypeImports are only needed for type checking, e.g. JSDoc import('./types') annotations.
+	TypeImports []string
+
+	// Data are files the js file references at runtime without importing them,
+	// e.g. through new URL('./data.bin', import.meta.url). Entries starting with
+	// a colon are labels of files in the package of the rule.
+	Data []string
+
+	// Mocks are the modules mocked with jest.mock('axios'), whose manual mocks in a
+	// __mocks__ directory jest uses instead.
+	Mocks []string
+
+	// DeclarationImports are the Imports only imported with import type or export type,
+	// which only need their declarations.
+	DeclarationImports []string
+
+	// CSSModules are the stylesheets imported as CSS module scripts with
+	// with { type: 'css' }, which are modules rather than data at runtime.
+	CSSModules []string
+
+	// Globals are the UMD globals a declaration file declares with export as namespace.
+	Globals []string
+
+	// GlobalRefs are the identifiers a ts file that is not a module accesses members of,
+	// like $ in $.ajax(), which may be UMD globals it uses without importing them.
+	GlobalRefs []string
+
+	// StoryComponents are the modules the component documented by a story may be defined
+	// in, e.g. ../ui/Button for a Button imported from the barrel file ../ui. They are only
+	// depended on if a rule provides them.
+	StoryComponents []string
 }
 
 var jsRe = buildJsRegexp()
 
+// jsDocImportRe matches the import('./types') type references in JSDoc comments.
+var jsDocImportRe = regexp.MustCompile(`\bimport\(\s*('[^']*'|"[^"]*")\s*\)`)
+
+// jsxPragmaRe matches the @jsx pragmas naming the factory of classic JSX like /** @jsx h */,
+// and the @jsxImportSource ones like /** @jsxImportSource preact */, which make the compiler
+// import the jsx runtime of the given package.
+var jsxPragmaRe = regexp.MustCompile(`(?m)^[ \t/*]*@jsx(ImportSource)?\s+([^\s*]+)`)
+
+// umdGlobalRe matches the export as namespace declarations of UMD declaration files.
+var umdGlobalRe = regexp.MustCompile(`(?m)^[ \t]*export\s+as\s+namespace\s+([A-Za-z_$][\w$]*)`)
+
+// moduleStmtRe matches the import and export statements making a file a module, which
+// can't use UMD globals without importing them.
+var moduleStmtRe = regexp.MustCompile(`(?m)^[ \t]*(?:import(?:\s+[\w${*]|\s*[{*'"])|export\b)`)
+
+// memberAccessRe matches the identifiers whose members are accessed, like $ in $.ajax().
+var memberAccessRe = regexp.MustCompile(`(?m)(?:^|[^\w$.])([A-Za-z_$][\w$]*)\s*\.[A-Za-z_$]`)
+
+// dynamicImportRe matches dynamic imports like () => import('./Comp') wherever they
+// appear in content stripped of its comments, so webpack magic comments, JSDoc
+// import('./types') type references and commented out imports don't get in the way. The
+// first group is set for TypeScript type queries like typeof import('./api'), which are
+// only needed for type checking.
+var dynamicImportRe = regexp.MustCompile(`(?m)(?:^|[^.\w$])(\btypeof\s+)?import\(\s*('[^'\n]*'|"[^"\n]*")\s*[,)]`)
+
+// splitComments returns js content with its comments blanked out, and the comments. What
+// only looks like a comment in a string, like the glob 'src/**/*.js', is left alone.
+// Newlines are kept, so lines stay where they were.
+func splitComments(content []byte) ([]byte, [][]byte) {
+	stripped := make([]byte, len(content))
+	copy(stripped, content)
+	var comments [][]byte
+	var quote byte
+	for i := 0; i < len(stripped); i++ {
+		c := stripped[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote || c == '\n' && quote != '`' {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '/' && i+1 < len(stripped) && stripped[i+1] == '/':
+			end := len(stripped)
+			if j := bytes.IndexByte(stripped[i:], '\n'); j >= 0 {
+				end = i + j
+			}
+			comments = append(comments, content[i:end])
+			for ; i < end; i++ {
+				stripped[i] = ' '
+			}
+		case c == '/' && i+1 < len(stripped) && stripped[i+1] == '*':
+			end := len(stripped)
+			if j := bytes.Index(stripped[i+2:], []byte("*/")); j >= 0 {
+				end = i + 2 + j + 2
+			}
+			comments = append(comments, content[i:end])
+			for ; i < end; i++ {
+				if stripped[i] != '\n' {
+					stripped[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	return stripped, comments
+}
+
+// typeOnlyStmtRe matches import type and export type statements, but not default imports
+// of a binding named type like import type from './type'.
+var typeOnlyStmtRe = regexp.MustCompile(`^(?:import|export)\s+type\s+(?:\{|\*|[\w$]+\s*,|[\w$]+\s+from\b)`)
+
+// declarationOnly returns the imports of typeImports that are not also among the value imports.
+func declarationOnly(imports, typeImports []string) []string {
+	values := make(map[string]bool)
+	for _, imp := range imports {
+		values[imp] = true
+	}
+	var only []string
+	for _, imp := range typeImports {
+		if !values[imp] {
+			only = append(only, imp)
+		}
+	}
+	return only
+}
+
+// importAttributesRe matches the import attributes following the module of an import,
+// like with { type: 'json' } or the older assert { type: 'json' }, with the closing quote
+// of the module. cssModuleImportRe matches the imports of CSS module scripts.
+var (
+	importAttributesRe = regexp.MustCompile(`(['"])[ \t]*(?:with|assert)\s*\{[^{}]*\}`)
+	cssModuleImportRe  = regexp.MustCompile(`(?m)^import\s[^'"]*?('[^'\n]*'|"[^"\n]*")[ \t]*(?:with|assert)\s*\{\s*type\s*:\s*(?:'css'|"css")\s*,?\s*\}`)
+)
+
+// jestMockRe matches the modules mocked with jest.mock or jest.doMock.
+var jestMockRe = regexp.MustCompile(`\bjest\.(?:mock|doMock)\(\s*('[^'\n]*'|"[^"\n]*")`)
+
+// importMetaURLRe matches module relative URLs like new URL('./data.bin', import.meta.url).
+var importMetaURLRe = regexp.MustCompile(`\bnew\s+URL\(\s*('\.{1,2}/[^']*'|"\.{1,2}/[^"]*")\s*,\s*import\.meta\.url\s*\)`)
+
+// importMetaResolveRe matches import.meta.resolve('./module'), which only locates a module
+// that has to be there at runtime.
+var importMetaResolveRe = regexp.MustCompile(`\bimport\.meta\.resolve\(\s*('[^'\n]*'|"[^"\n]*")\s*\)`)
+
+// requireContextRe matches webpack's require.context(directory, useSubdirectories, regExp)
+// with literal arguments.
+var requireContextRe = regexp.MustCompile(`\brequire\.context\(\s*('[^']*'|"[^"]*")\s*(?:,\s*(true|false)\s*(?:,\s*/((?:\\.|[^/\\\n])+)/[gimsuy]*\s*)?)?\)`)
+
+// styleExtensions are the stylesheets parsed for the assets they reference.
+var styleExtensions = []string{".css", ".scss", ".sass", ".less", ".styl"}
+
+// cssImportRe matches @import rules, whose url or string may be followed by layer(),
+// supports() and media query conditions, e.g. @import url('./print.css') print;
+// cssURLRe matches url() references, which also cover the src list of @font-face.
+// cssImageSetRe matches image-set() lists, whose candidates may be plain strings
+// found with cssStringRe.
+var (
+	cssCommentRe  = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssImportRe   = regexp.MustCompile(`@import\s+(?:url\(\s*('[^']*'|"[^"]*"|[^'")\s]+)\s*\)|('[^']*'|"[^"]*"))[^;]*;?`)
+	cssURLRe      = regexp.MustCompile(`\burl\(\s*('[^']*'|"[^"]*"|[^'")\s]+)\s*\)`)
+	cssImageSetRe = regexp.MustCompile(`\bimage-set\(((?:[^()]|\([^()]*\))*)\)`)
+	cssStringRe   = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+)
+
+// stylusLineCommentRe matches the line comments of Stylus, which are not preceded by
+// a colon like the // in https://. stylusImportRe matches @import and @require of
+// Stylus files, which may omit the extension.
+var (
+	stylusLineCommentRe = regexp.MustCompile(`(?m)(?:^|[^:])//.*$`)
+	stylusImportRe      = regexp.MustCompile(`@(?:import|require)\s+('[^'\n]*'|"[^"\n]*")`)
+)
+
+// cssFileinfo takes a dir and file name and parses the stylesheet for the
+// stylesheets it imports, which are returned as Imports, and the files it
+// references, like fonts and images, which are returned as Data.
+func cssFileinfo(dir, name string) FileInfo {
+	info := FileInfo{
+		Path: filepath.Join(dir, name),
+		Name: name,
+	}
+	content, err := ioutil.ReadFile(info.Path)
+	if err != nil {
+		log.Printf("%s: error reading css file: %v", info.Path, err)
+		return info
+	}
+	content = cssCommentRe.ReplaceAll(content, nil)
+
+	if path.Ext(name) == ".styl" {
+		content = stylusLineCommentRe.ReplaceAll(content, nil)
+		for _, match := range stylusImportRe.FindAllSubmatch(content, -1) {
+			if imp := stylusImportPath(dir, strings.Trim(string(match[1]), `'"`)); imp != "" {
+				info.Imports = append(info.Imports, imp)
+			}
+		}
+		content = stylusImportRe.ReplaceAll(content, nil)
+	} else {
+		for _, match := range cssImportRe.FindAllSubmatch(content, -1) {
+			imp := string(match[1]) + string(match[2])
+			if imp = cssAssetPath(strings.Trim(imp, `'"`)); imp != "" {
+				info.Imports = append(info.Imports, imp)
+			}
+		}
+		content = cssImportRe.ReplaceAll(content, nil)
+	}
+	sort.Strings(info.Imports)
+
+	var refs []string
+	for _, match := range cssURLRe.FindAllSubmatch(content, -1) {
+		refs = append(refs, strings.Trim(string(match[1]), `'"`))
+	}
+	for _, match := range cssImageSetRe.FindAllSubmatch(content, -1) {
+		for _, s := range cssStringRe.FindAll(match[1], -1) {
+			refs = append(refs, strings.Trim(string(s), `'"`))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		ref = cssAssetPath(ref)
+		if ref != "" && !seen[ref] {
+			seen[ref] = true
+			info.Data = append(info.Data, ref)
+		}
+	}
+	sort.Strings(info.Data)
+
+	return info
+}
+
+// stylusImportPath finds the file a Stylus import in dir refers to the way Stylus does,
+// trying the path itself, with a .styl extension, as an underscore prefixed partial and
+// as a directory with an index.styl. Imports of plugins like nib and globs, which are
+// not files of the repository, result in an empty string.
+func stylusImportPath(dir, imp string) string {
+	if imp = cssAssetPath(imp); imp == "" {
+		return ""
+	}
+	partial := path.Join(path.Dir(imp), "_"+path.Base(imp))
+	for _, candidate := range []string{imp, imp + ".styl", partial + ".styl", path.Join(imp, "index.styl")} {
+		candidate = path.Clean(candidate)
+		if fi, err := os.Stat(filepath.Join(dir, candidate)); err == nil && !fi.IsDir() {
+			if !strings.HasPrefix(candidate, "../") {
+				candidate = "./" + candidate
+			}
+			return candidate
+		}
+	}
+	return ""
+}
+
+// cssAssetPath turns a url referenced in a stylesheet into a relative import, dropping any
+// query or fragment. Empty strings are returned for urls not pointing to files in the repository.
+func cssAssetPath(ref string) string {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	if ref == "" || strings.HasPrefix(ref, "/") || strings.Contains(ref, ":") {
+		// Fragments, absolute paths, data: uris and external urls
+		return ""
+	}
+	if !strings.HasPrefix(ref, "./") && !strings.HasPrefix(ref, "../") {
+		ref = "./" + ref
+	}
+	return ref
+}
+
+// assetAssignmentRe matches relative string literals assigned to src or href properties,
+// like img.src = './sprite.png'.
+var assetAssignmentRe = regexp.MustCompile(`\.(?:src|href)\s*=\s*('\.{1,2}/[^'\n]*'|"\.{1,2}/[^"\n]*")`)
+
+// assetExtensions are the files assetAssignments considers, to avoid picking up
+// assignments of pages or scripts.
+var assetExtensions = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico", ".bmp",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp3", ".mp4", ".ogg", ".wav", ".webm",
+}
+
+// assetAssignments returns the assets a js file loads by assigning their relative
+// paths to src or href properties instead of importing them.
+func assetAssignments(dir, name string) []string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		log.Printf("%s: error reading js file: %v", filepath.Join(dir, name), err)
+		return nil
+	}
+	var assets []string
+	seen := make(map[string]bool)
+	for _, match := range assetAssignmentRe.FindAllSubmatch(content, -1) {
+		asset := strings.Trim(string(match[1]), `'"`)
+		if containsSuffix(assetExtensions, asset) && !seen[asset] {
+			seen[asset] = true
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets)
+	return assets
+}
+
+// cssInJsTemplateRe matches the tagged templates of styled-components and emotion, like
+// styled.div`...`, styled(Button)`...`, css`...` and createGlobalStyle`...`.
+var cssInJsTemplateRe = regexp.MustCompile(`(?:\bstyled\s*(?:\.\s*[\w$]+|\(\s*[\w$.]+\s*\))|\b(?:css|createGlobalStyle|injectGlobal|keyframes))\s*` + "`((?:[^`\\\\]|\\\\.)*)`")
+
+// cssInJsAssets returns the files referenced with url() in the css-in-js templates of
+// a js file, like the fonts of a createGlobalStyle. Interpolated urls are skipped.
+func cssInJsAssets(dir, name string) []string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		log.Printf("%s: error reading js file: %v", filepath.Join(dir, name), err)
+		return nil
+	}
+	var assets []string
+	seen := make(map[string]bool)
+	for _, template := range cssInJsTemplateRe.FindAllSubmatch(content, -1) {
+		for _, match := range cssURLRe.FindAllSubmatch(template[1], -1) {
+			ref := strings.Trim(string(match[1]), `'"`)
+			if strings.Contains(ref, "${") {
+				continue
+			}
+			if asset := cssAssetPath(ref); asset != "" && !seen[asset] {
+				seen[asset] = true
+				assets = append(assets, asset)
+			}
+		}
+	}
+	sort.Strings(assets)
+	return assets
+}
+
+// csfDefaultExportRe matches the default export of a Storybook Component Story Format
+// file, either an object literal or the name of the variable holding it, and
+// csfComponentRe the component field naming the documented component.
+var (
+	csfDefaultExportRe = regexp.MustCompile(`\bexport\s+default\s+(?:(\{)|([A-Za-z_$][\w$]*))`)
+	csfComponentRe     = regexp.MustCompile(`\bcomponent\s*:\s*([A-Za-z_$][\w$]*)`)
+)
+
+// storyComponent returns the component a story documents, as named by the component
+// field of its default export, e.g. Button for export default { component: Button }.
+func storyComponent(content []byte) string {
+	match := csfDefaultExportRe.FindSubmatchIndex(content)
+	if match == nil {
+		return ""
+	}
+	start := match[2]
+	if start < 0 {
+		// export default meta; refers to const meta: Meta<typeof Button> = { ... }
+		name := regexp.QuoteMeta(string(content[match[4]:match[5]]))
+		decl := regexp.MustCompile(`\b(?:const|let|var)\s+` + name + `\b[^=]*=\s*\{`).FindIndex(content)
+		if decl == nil {
+			return ""
+		}
+		start = decl[1] - 1
+	}
+	// Only the fields of the object itself are considered, not those of nested objects
+	depth, end := 0, len(content)
+	var fields []byte
+	for i := start; i < end; i++ {
+		switch content[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+		if depth == 1 {
+			fields = append(fields, content[i])
+		}
+	}
+	if m := csfComponentRe.FindSubmatch(fields); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// storyComponentImport returns the module the component a story documents is defined in
+// if the story imports it by name from another module, e.g. ../ui/Button for
+// import { Button } from '../ui', or "" otherwise. Default imports are the component itself.
+func storyComponentImport(code []byte, path string) string {
+	component := storyComponent(code)
+	if component == "" {
+		return ""
+	}
+	binding, ok := importBindings(code, path)[component]
+	if !ok || binding.Name == "default" || binding.Name == "*" {
+		return ""
+	}
+	return strings.TrimSuffix(binding.Source, "/") + "/" + binding.Name
+}
+
+// importBinding is what a local name is imported as: the export Name of the module
+// Source, which is "default" for default imports and "*" for namespace imports.
+type importBinding struct {
+	Source, Name string
+}
+
+// importClauseRe matches the import statements binding names, capturing their import
+// clause like Card, { Button as Btn } and their module.
+var importClauseRe = regexp.MustCompile(`\bimport\s+(?:type\s+)?([\w$*{][^'";]*?)\s*\bfrom\s*('[^'\n]*'|"[^"\n]*")`)
+
+// importBindings returns the names the import statements of code bind, by local name.
+func importBindings(code []byte, path string) map[string]importBinding {
+	bindings := make(map[string]importBinding)
+	for _, match := range importClauseRe.FindAllSubmatch(code, -1) {
+		source := unquoteImportString(match[2], path)
+		clause := string(match[1])
+		if open := strings.Index(clause, "{"); open >= 0 {
+			end := strings.Index(clause, "}")
+			if end < open {
+				continue
+			}
+			for _, spec := range strings.Split(clause[open+1:end], ",") {
+				fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(spec), "type "))
+				switch {
+				case len(fields) == 1:
+					bindings[fields[0]] = importBinding{Source: source, Name: fields[0]}
+				case len(fields) == 3 && fields[1] == "as":
+					bindings[fields[2]] = importBinding{Source: source, Name: fields[0]}
+				}
+			}
+			clause = clause[:open] + clause[end+1:]
+		}
+		for _, part := range strings.Split(clause, ",") {
+			fields := strings.Fields(part)
+			switch {
+			case len(fields) == 1:
+				bindings[fields[0]] = importBinding{Source: source, Name: "default"}
+			case len(fields) == 3 && fields[0] == "*" && fields[1] == "as":
+				bindings[fields[2]] = importBinding{Source: source, Name: "*"}
+			}
+		}
+	}
+	return bindings
+}
+
 // jsFileinfo takes a dir and file name and parses the js file into
 // the constituent components, extracting metadata like the set of
 // imports that it has.
@@ -48,9 +481,76 @@ func jsFileinfo(dir, name string) FileInfo {
 		log.Printf("%s: error reading js file: %v", info.Path, err)
 		return info
 	}
+	if strings.HasSuffix(name, ".vue") {
+		content = dedentVueScripts(content)
+	}
+	return parseJs(info, dir, content)
+}
 
-	for _, match := range jsRe.FindAllSubmatch(content, -1) {
+// vueScriptRe matches the script blocks of Vue single file components, and
+// indentRe the indentation of their lines.
+var (
+	vueScriptRe = regexp.MustCompile(`(?s)<script\b[^>]*>.*?</script>`)
+	indentRe    = regexp.MustCompile(`(?m)^[ \t]+`)
+)
+
+// dedentVueScripts removes the indentation of the script blocks of a Vue single file
+// component, which style guides like vue/script-indent call for, so their imports are
+// at the start of a line like in js files.
+func dedentVueScripts(content []byte) []byte {
+	return vueScriptRe.ReplaceAllFunc(content, func(block []byte) []byte {
+		return indentRe.ReplaceAll(block, nil)
+	})
+}
+
+// mdxFileinfo takes a dir and file name and parses the imports of the MDX file. Only
+// the ESM blocks are parsed, i.e. paragraphs starting with import or export outside
+// of code blocks, as code samples in the documentation often contain imports too.
+func mdxFileinfo(dir, name string) FileInfo {
+	info := FileInfo{
+		Path: filepath.Join(dir, name),
+		Name: name,
+	}
+	content, err := ioutil.ReadFile(info.Path)
+	if err != nil {
+		log.Printf("%s: error reading mdx file: %v", info.Path, err)
+		return info
+	}
+	var esm bytes.Buffer
+	inCode, inESM := false, false
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
 		switch {
+		case bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~")):
+			inCode = !inCode
+			inESM = false
+		case inCode:
+		case len(trimmed) == 0:
+			inESM = false
+		case !inESM && (bytes.HasPrefix(line, []byte("import ")) || bytes.HasPrefix(line, []byte("export "))):
+			inESM = true
+		}
+		if inESM {
+			esm.Write(line)
+			esm.WriteByte('\n')
+		}
+	}
+	return parseJs(info, dir, esm.Bytes())
+}
+
+// parseJs extracts the imports and other references of the js source content into info.
+func parseJs(info FileInfo, dir string, content []byte) FileInfo {
+	for _, match := range cssModuleImportRe.FindAllSubmatch(content, -1) {
+		info.CSSModules = append(info.CSSModules, unquoteImportString(match[1], info.Path))
+	}
+	content = importAttributesRe.ReplaceAll(content, []byte("$1"))
+	code, comments := splitComments(content)
+	for _, match := range jsRe.FindAllSubmatch(code, -1) {
+		switch {
+		case match[importSubexpIndex] != nil && typeOnlyStmtRe.Match(match[0]):
+			imp := match[importSubexpIndex]
+			info.DeclarationImports = append(info.DeclarationImports, unquoteImportString(imp, info.Path))
+
 		case match[importSubexpIndex] != nil:
 			imp := match[importSubexpIndex]
 			info.Imports = append(info.Imports, (unquoteImportString(imp, info.Path)))
@@ -59,6 +559,10 @@ func jsFileinfo(dir, name string) FileInfo {
 			imp := match[requireSubexpIndex]
 			info.Imports = append(info.Imports, (unquoteImportString(imp, info.Path)))
 
+		case match[exportSubexpIndex] != nil && typeOnlyStmtRe.Match(match[0]):
+			imp := match[exportSubexpIndex]
+			info.DeclarationImports = append(info.DeclarationImports, unquoteImportString(imp, info.Path))
+
 		case match[exportSubexpIndex] != nil:
 			imp := match[exportSubexpIndex]
 			info.Imports = append(info.Imports, (unquoteImportString(imp, info.Path)))
@@ -67,11 +571,138 @@ func jsFileinfo(dir, name string) FileInfo {
 			// Comment matched. Nothing to extract.
 		}
 	}
+	for _, match := range requireContextRe.FindAllSubmatch(content, -1) {
+		contextDir := unquoteImportString(match[1], info.Path)
+		recursive := match[2] == nil || string(match[2]) == "true"
+		pattern := `^\./.*$`
+		if match[3] != nil {
+			pattern = string(match[3])
+		}
+		info.Imports = append(info.Imports, expandRequireContext(dir, contextDir, recursive, pattern)...)
+	}
+	for _, match := range dynamicImportRe.FindAllSubmatch(code, -1) {
+		imp := unquoteImportString(match[2], info.Path)
+		if match[1] != nil {
+			info.TypeImports = append(info.TypeImports, imp)
+		} else {
+			info.Imports = append(info.Imports, imp)
+		}
+	}
+	for _, match := range importMetaResolveRe.FindAllSubmatch(code, -1) {
+		info.Imports = append(info.Imports, unquoteImportString(match[1], info.Path))
+	}
+	for _, match := range jestMockRe.FindAllSubmatch(code, -1) {
+		info.Mocks = append(info.Mocks, unquoteImportString(match[1], info.Path))
+	}
+	for _, match := range importMetaURLRe.FindAllSubmatch(content, -1) {
+		info.Data = append(info.Data, unquoteImportString(match[1], info.Path))
+	}
+	if strings.Contains(info.Name, ".stories.") {
+		// The documented component may be imported through a barrel file
+		if imp := storyComponentImport(code, info.Path); imp != "" {
+			info.StoryComponents = append(info.StoryComponents, imp)
+		}
+	}
+	// @jsx pragmas name a factory the compiled code calls, which has to be imported as a
+	// value, @jsxImportSource ones a package whose runtime the compiler imports
+	for _, match := range jsxPragmaRe.FindAllSubmatch(content, -1) {
+		if match[1] != nil {
+			info.Imports = append(info.Imports, string(match[2])+"/jsx-runtime")
+			continue
+		}
+		factory := strings.SplitN(string(match[2]), ".", 2)[0]
+		if binding, ok := importBindings(code, info.Path)[factory]; ok && !containsString(info.Imports, binding.Source) {
+			info.Imports = append(info.Imports, binding.Source)
+		}
+	}
+	if strings.HasSuffix(info.Name, ".d.ts") {
+		for _, match := range umdGlobalRe.FindAllSubmatch(content, -1) {
+			info.Globals = append(info.Globals, string(match[1]))
+		}
+	} else if (strings.HasSuffix(info.Name, ".ts") || strings.HasSuffix(info.Name, ".tsx")) && !moduleStmtRe.Match(code) {
+		info.GlobalRefs = memberAccesses(code)
+	}
+	for _, comment := range comments {
+		if !bytes.HasPrefix(comment, []byte("/**")) {
+			continue
+		}
+		for _, match := range jsDocImportRe.FindAllSubmatch(comment, -1) {
+			info.TypeImports = append(info.TypeImports, unquoteImportString(match[1], info.Path))
+		}
+	}
+	info.DeclarationImports = declarationOnly(info.Imports, info.DeclarationImports)
+	info.Imports = append(info.Imports, info.DeclarationImports...)
 	sort.Strings(info.Imports)
+	info.TypeImports = sortedUnique(info.TypeImports)
+	sort.Strings(info.Data)
+	sort.Strings(info.Mocks)
+	sort.Strings(info.CSSModules)
+	sort.Strings(info.DeclarationImports)
 
 	return info
 }
 
+// sortedUnique sorts strs and drops its duplicates, e.g. a type imported by several JSDoc
+// annotations.
+func sortedUnique(strs []string) []string {
+	sort.Strings(strs)
+	var unique []string
+	for i, s := range strs {
+		if i == 0 || s != strs[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// memberAccesses returns the sorted identifiers whose members content accesses.
+func memberAccesses(content []byte) []string {
+	seen := make(map[string]bool)
+	var refs []string
+	for _, match := range memberAccessRe.FindAllSubmatch(content, -1) {
+		if ref := string(match[1]); !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// expandRequireContext returns an import for each file matched by a require.context call the
+// way webpack does, i.e. by testing the regexp against paths relative to the context directory.
+func expandRequireContext(dir, contextDir string, recursive bool, pattern string) []string {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("%s: unsupported require.context regexp /%s/: %v", filepath.Join(dir, contextDir), pattern, err)
+		return nil
+	}
+	root := filepath.Join(dir, contextDir)
+	var imports []string
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi.IsDir() {
+			if p != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if re.MatchString("./" + rel) {
+			imp := trimSourceExt(path.Join(contextDir, rel))
+			if !strings.HasPrefix(imp, "../") {
+				imp = "./" + imp
+			}
+			imports = append(imports, imp)
+		}
+		return nil
+	})
+	return imports
+}
+
 // unquoteImportString takes a string that has a complex quoting around it
 // and returns a string without the complex quoting.
 func unquoteImportString(q []byte, path string) string {
@@ -117,11 +748,15 @@ func buildJsRegexp() *regexp.Regexp {
 	strLit := `'(?:` + charValue + `|")*'|"(?:` + charValue + `|')*"`
 	importStmt := `(?m)^import\s(?:(?:.|\n)+?from )??(?P<import>` + strLit + `).*?`
 
-
-	requireStmt := `(?m)^\s*?(?:const .+ = )?require\((?P<require>` + strLit + `)\).*`
+	// Requires are dependencies wherever they appear, e.g. also when only made in development:
+	// if (process.env.NODE_ENV === 'development') { require('./devtools') }
+	requireStmt := `(?m)(?:^|[^.\w$])require\(\s*(?P<require>'[^'\n]*'|"[^"\n]*")\s*\)`
 
 	exportStmt := `(?m)^export\s(?:(?:.|\n)+?from )??(?P<export>` + strLit + `).*?`
 
-	jsReSrc := strings.Join([]string{importStmt, requireStmt, exportStmt}, "|")
+	// Line comments are matched first, so the requires in them are skipped
+	lineComment := `(?m)^[ \t]*//.*$`
+
+	jsReSrc := strings.Join([]string{lineComment, importStmt, requireStmt, exportStmt}, "|")
 	return regexp.MustCompile(jsReSrc)
 }
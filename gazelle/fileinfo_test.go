@@ -127,6 +127,10 @@ import {
     '^~/(.+\\.svg)(\\?inline)?$': '<rootDir>$1',
 // const a = require("date-fns");
 // import {format} from 'date-fns';
+/*
+import {parse} from 'date-fns';
+export * from './legacy';
+*/
 `,
 			want: FileInfo{
 				Imports: []string(nil),
@@ -176,6 +180,229 @@ if (process.ENV.SHOULD_IMPORT) {
 				Imports: []string{"mapbox.js"},
 			},
 		},
+		{
+			desc: "conditional requires",
+			name: "conditional_require.js",
+			js: `
+if (process.env.NODE_ENV === 'development') { require('./devtools') }
+const logger = process.env.DEBUG ? require("./verbose-logger") : require('./logger');
+module.exports = { store: require('./store'), config: options.require('not-a-module') };
+`,
+			want: FileInfo{
+				Imports: []string{"./devtools", "./logger", "./store", "./verbose-logger"},
+			},
+		},
+		{
+			desc: "import.meta.url relative resource",
+			name: "resource.js",
+			js: `
+const data = await fetch(new URL('./data.bin', import.meta.url));
+const image = new URL("../assets/logo.png", import.meta.url).href;
+const remote = new URL('https://example.com/data.bin', import.meta.url);
+const worker = new Worker(new URL('./worker.js', import.meta.url), { type: 'module' });
+`,
+			want: FileInfo{
+				Data: []string{"../assets/logo.png", "./data.bin", "./worker.js"},
+			},
+		},
+		{
+			desc: "jsdoc type imports",
+			name: "jsdoc.js",
+			js: `import { format } from 'date-fns';
+
+/** @typedef {import('./types').User} User */
+
+/**
+ * @param {import("./types").User} user
+ * @returns {import('@acme/models').Profile}
+ */
+export function profile(user) {
+  // import('./not-jsdoc') in a line comment is ignored
+  return format(user.createdAt);
+}
+`,
+			want: FileInfo{
+				Imports:     []string{"date-fns"},
+				TypeImports: []string{"./types", "@acme/models"},
+			},
+		},
+		{
+			desc: "dynamic imports",
+			name: "components.ts",
+			js: `import Vue from 'vue';
+
+Vue.component('AsyncChart', () => import('./Chart.vue'));
+Vue.component('AsyncMap', function () {
+  return import(/* webpackChunkName: "map" */ "./Map.vue");
+});
+const routes = [{ path: '/admin', component: () => import('@/views/Admin.vue', { with: {} }) }];
+const specs = glob('src/**/*.spec.js'), Settings = () => import('./Settings.vue'); // import('./trailing-comment')
+const url = "https://example.com", Help = () => import('./Help.vue');
+
+// import('./commented-out')
+/* () => import('./also-commented-out') */
+type Api = typeof import('./api');
+const lazy = loader.import('not-a-dynamic-import');
+`,
+			want: FileInfo{
+				Imports:     []string{"./Chart.vue", "./Help.vue", "./Map.vue", "./Settings.vue", "@/views/Admin.vue", "vue"},
+				TypeImports: []string{"./api"},
+			},
+		},
+		{
+			desc: "jest mocks",
+			name: "api.test.js",
+			js: `import axios from 'axios';
+import { fetchUser } from './api';
+
+jest.mock('axios');
+jest.doMock("@acme/analytics", () => ({ track: jest.fn() }));
+jest.mock('./cache');
+// jest.mock('./storage');
+/* jest.mock('lodash'); */
+`,
+			want: FileInfo{
+				Imports: []string{"./api", "axios"},
+				Mocks:   []string{"./cache", "@acme/analytics", "axios"},
+			},
+		},
+		{
+			desc: "vue class component",
+			name: "Page.vue",
+			js: `<template>
+  <Card><Avatar :user="user" /></Card>
+</template>
+
+<script lang="ts">
+  import { Component, Prop, Vue } from 'vue-property-decorator'
+  import Card from './Card.vue'
+  import type { User } from '@/types'
+
+  @Component({
+    components: {
+      Card,
+      Avatar: () => import('./Avatar.vue'),
+    },
+  })
+  export default class Page extends Vue {
+    @Prop({ type: Object, required: true }) readonly user!: User
+  }
+</script>
+`,
+			want: FileInfo{
+				Imports:            []string{"./Avatar.vue", "./Card.vue", "@/types", "vue-property-decorator"},
+				DeclarationImports: []string{"@/types"},
+			},
+		},
+		{
+			desc: "type-only imports",
+			name: "service.ts",
+			js: `import type { User } from '@acme/models';
+import type * as api from './api';
+import type Config, { Options } from './config';
+import { type Session, login } from './auth';
+import type { Token } from './auth';
+export type { Role } from './roles';
+import type from './type';
+`,
+			want: FileInfo{
+				Imports:            []string{"./api", "./auth", "./config", "./roles", "./type", "@acme/models"},
+				DeclarationImports: []string{"./api", "./config", "./roles", "@acme/models"},
+			},
+		},
+		{
+			desc: "import.meta.resolve",
+			name: "loader.js",
+			js: `const workerURL = import.meta.resolve('./worker.js');
+const themeURL = import.meta.resolve("@acme/theme/dark.css");
+const dynamic = import.meta.resolve(name);
+// const legacyURL = import.meta.resolve('./legacy-worker.js');
+/* import.meta.resolve('./commented.css') */
+`,
+			want: FileInfo{
+				Imports: []string{"./worker.js", "@acme/theme/dark.css"},
+			},
+		},
+		{
+			desc: "import attributes",
+			name: "card.js",
+			js: `import sheet from './card.css' with { type: 'css' };
+import theme from "./theme.css" assert { type: "css" };
+import config from './config.json' with { type: 'json' };
+export { default as data } from './data.json' with { type: 'json' };
+`,
+			want: FileInfo{
+				Imports:    []string{"./card.css", "./config.json", "./data.json", "./theme.css"},
+				CSSModules: []string{"./card.css", "./theme.css"},
+			},
+		},
+		{
+			desc: "jsx pragma",
+			name: "pragma.jsx",
+			js: `/** @jsx h */
+import { h } from 'preact';
+
+export const App = () => <div />;
+`,
+			want: FileInfo{
+				Imports: []string{"preact"},
+			},
+		},
+		{
+			desc: "story component",
+			name: "Button.stories.tsx",
+			js: `import type { Meta } from '@storybook/react';
+import { Button as UiButton } from '../ui';
+
+const meta: Meta<typeof UiButton> = { component: UiButton };
+export default meta;
+`,
+			want: FileInfo{
+				Imports:            []string{"../ui", "@storybook/react"},
+				DeclarationImports: []string{"@storybook/react"},
+				StoryComponents:    []string{"../ui/Button"},
+			},
+		},
+		{
+			desc: "story of a default import",
+			name: "Card.stories.js",
+			js: `import Card from './Card';
+
+export default { component: Card };
+`,
+			want: FileInfo{
+				Imports: []string{"./Card"},
+			},
+		},
+		{
+			desc: "jsx pragma of a namespace",
+			name: "namespace.tsx",
+			js: `/** @jsx preact.h */
+import * as preact from 'preact';
+import type { FunctionComponent } from 'preact/compat';
+
+export const App: FunctionComponent = () => <div />;
+`,
+			want: FileInfo{
+				Imports:            []string{"preact", "preact/compat"},
+				DeclarationImports: []string{"preact/compat"},
+			},
+		},
+		{
+			desc: "jsx import source pragma",
+			name: "source.tsx",
+			js: `/**
+ * @jsxImportSource preact
+ */
+// @jsxImportSource @emotion/react
+const pragma = "@jsxImportSource not-a-pragma";
+
+export const App = () => <div />;
+`,
+			want: FileInfo{
+				Imports: []string{"@emotion/react/jsx-runtime", "preact/jsx-runtime"},
+			},
+		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestProtoFileinfo")
@@ -191,11 +418,459 @@ if (process.ENV.SHOULD_IMPORT) {
 
 			// Reexpose the fields we care bout for testing.
 			got = FileInfo{
-				Imports: got.Imports,
+				Imports:            got.Imports,
+				TypeImports:        got.TypeImports,
+				Data:               got.Data,
+				Mocks:              got.Mocks,
+				DeclarationImports: got.DeclarationImports,
+				CSSModules:         got.CSSModules,
+				StoryComponents:    got.StoryComponents,
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJsFileInfoRequireContext(t *testing.T) {
+	for _, tc := range []struct {
+		desc, js string
+		want     []string
+	}{
+		{
+			desc: "recursive context",
+			js:   `const plugins = require.context('./plugins', true, /\.js$/);`,
+			want: []string{"./plugins/a", "./plugins/b", "./plugins/nested/c"},
+		},
+		{
+			desc: "non-recursive context",
+			js:   `const plugins = require.context("./plugins", false, /\.js$/);`,
+			want: []string{"./plugins/a", "./plugins/b"},
+		},
+		{
+			desc: "default arguments",
+			js:   `const all = require.context('./plugins');`,
+			want: []string{"./plugins/README.md", "./plugins/a", "./plugins/b", "./plugins/nested/c", "./plugins/nested/d"},
+		},
+		{
+			desc: "non-literal arguments are skipped",
+			js:   `const plugins = require.context(pluginDir, true, /\.js$/);`,
+			want: []string(nil),
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestJsFileInfoRequireContext")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range map[string]string{
+				"index.js":            tc.js,
+				"plugins/a.js":        "",
+				"plugins/b.js":        "",
+				"plugins/README.md":   "",
+				"plugins/nested/c.js": "",
+				"plugins/nested/d.ts": "",
+			} {
+				p := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := jsFileinfo(dir, "index.js")
+
+			if !reflect.DeepEqual(got.Imports, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got.Imports, tc.want)
+			}
+		})
+	}
+}
+
+func TestCssFileInfo(t *testing.T) {
+	for _, tc := range []struct {
+		desc, css string
+		imports   []string
+		want      []string
+	}{
+		{
+			desc: "font-face src list",
+			css: `@font-face {
+  font-family: "Inter";
+  src: url('./fonts/inter.woff2') format("woff2"),
+       url("./fonts/inter.woff?v=2") format("woff"),
+       local("Inter");
+}`,
+			want: []string{"./fonts/inter.woff", "./fonts/inter.woff2"},
+		},
+		{
+			desc: "image-set strings",
+			css:  `.hero { background-image: image-set('./a.png' 1x, "./b.png" 2x); }`,
+			want: []string{"./a.png", "./b.png"},
+		},
+		{
+			desc: "image-set urls",
+			css:  `.hero { background-image: -webkit-image-set(url(a.png) 1x, url(../img/b.png) 2x); }`,
+			want: []string{"../img/b.png", "./a.png"},
+		},
+		{
+			desc: "external and inline urls are skipped",
+			css: `/* url('./commented.png') */
+.a { background: url(data:image/png;base64,AAAA) }
+.b { background: url("https://example.com/b.png") }
+.c { mask: url(#mask) }
+.d { background: url(/static/d.png) }`,
+			want: []string(nil),
+		},
+		{
+			desc: "imports with conditions",
+			css: `@import url('./print.css') print;
+@import "./grid.css" supports(display: grid) screen and (max-width: 400px);
+@import url(theme.css) layer(theme);
+@import 'https://fonts.googleapis.com/css?family=Inter';
+.logo { background: url(./logo.png) }`,
+			imports: []string{"./grid.css", "./print.css", "./theme.css"},
+			want:    []string{"./logo.png"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestCssFileInfo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "styles.css"), []byte(tc.css), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			info := cssFileinfo(dir, "styles.css")
+
+			if !reflect.DeepEqual(info.Imports, tc.imports) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.Imports, tc.imports)
+			}
+			if !reflect.DeepEqual(info.Data, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.Data, tc.want)
+			}
+		})
+	}
+}
+
+func TestStoryComponent(t *testing.T) {
+	for _, tc := range []struct {
+		desc, js, want string
+	}{
+		{
+			desc: "object literal",
+			js: `import { Button } from '@/components';
+
+export default {
+  title: 'Inputs/Button',
+  component: Button,
+};`,
+			want: "Button",
+		},
+		{
+			desc: "meta variable",
+			js: `import type { Meta, StoryObj } from '@storybook/react';
+import { Card } from '../ui';
+
+const meta: Meta<typeof Card> = {
+  args: { component: Other },
+  component: Card,
+};
+
+export default meta;
+export const Primary: StoryObj<typeof Card> = {};`,
+			want: "Card",
+		},
+		{
+			desc: "satisfies",
+			js: `const meta = { component: Modal } satisfies Meta<typeof Modal>;
+export default meta;`,
+			want: "Modal",
+		},
+		{
+			desc: "nested only",
+			js:   `export default { title: 'Docs', parameters: { component: Ignored } };`,
+			want: "",
+		},
+		{
+			desc: "no default export",
+			js:   `export const Primary = { component: Button };`,
+			want: "",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := storyComponent([]byte(tc.js)); got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportBindings(t *testing.T) {
+	js := `import Card, { Button as Btn, type Size } from '../ui';
+import * as icons from "./icons";
+import { Modal } from '@acme/modal';
+import type Theme from './theme';
+import './polyfill';
+`
+	want := map[string]importBinding{
+		"Card":  {Source: "../ui", Name: "default"},
+		"Btn":   {Source: "../ui", Name: "Button"},
+		"Size":  {Source: "../ui", Name: "Size"},
+		"icons": {Source: "./icons", Name: "*"},
+		"Modal": {Source: "@acme/modal", Name: "Modal"},
+		"Theme": {Source: "./theme", Name: "default"},
+	}
+
+	got := importBindings([]byte(js), "bindings.ts")
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestStylusFileInfo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestStylusFileInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"theme/main.styl": `@import 'mixins'
+@import "_variables"
+@require 'buttons'
+@require '../base'
+@import 'nib'
+// @import 'commented'
+.logo
+  background url('./logo.png')
+  font-family url("https://fonts.example.com/inter.woff")
+`,
+		"theme/mixins.styl":     "",
+		"theme/_variables.styl": "",
+		"theme/_buttons.styl":   "",
+		"theme/commented.styl":  "",
+		"base/index.styl":       "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := cssFileinfo(filepath.Join(dir, "theme"), "main.styl")
+
+	if want := []string{"../base/index.styl", "./_buttons.styl", "./_variables.styl", "./mixins.styl"}; !reflect.DeepEqual(info.Imports, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.Imports, want)
+	}
+	if want := []string{"./logo.png"}; !reflect.DeepEqual(info.Data, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.Data, want)
+	}
+}
+
+func TestAssetAssignments(t *testing.T) {
+	for _, tc := range []struct {
+		desc, js string
+		want     []string
+	}{
+		{
+			desc: "src and href",
+			js: `const img = new Image();
+img.src = './sprite.png';
+link.href = "../icons/favicon.ico";`,
+			want: []string{"../icons/favicon.ico", "./sprite.png"},
+		},
+		{
+			desc: "only relative assets",
+			js: `img.src = '/static/logo.png';
+img.src = 'https://example.com/logo.png';
+script.src = './analytics.js';
+a.href = './about.html';
+video.src = computeSource();`,
+			want: []string(nil),
+		},
+		{
+			desc: "duplicates",
+			js: `a.src = './a.svg';
+b.src = './a.svg';`,
+			want: []string{"./a.svg"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestAssetAssignments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "main.js"), []byte(tc.js), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got := assetAssignments(dir, "main.js")
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
 			}
+		})
+	}
+}
+
+func TestCSSInJsAssets(t *testing.T) {
+	for _, tc := range []struct {
+		desc, js string
+		want     []string
+	}{
+		{
+			desc: "global style fonts",
+			js: `import { createGlobalStyle } from 'styled-components';
+
+export const GlobalStyle = createGlobalStyle` + "`" + `
+  @font-face {
+    font-family: 'Inter';
+    src: url('./fonts/inter.woff2') format('woff2'), url("./fonts/inter.woff") format('woff');
+  }
+` + "`" + `;`,
+			want: []string{"./fonts/inter.woff", "./fonts/inter.woff2"},
+		},
+		{
+			desc: "styled components and emotion",
+			js: `const Hero = styled.section` + "`" + `background: url(../images/hero.jpg) no-repeat;` + "`" + `;
+const Card = styled(Box)` + "`" + `background-image: url('./card.png');` + "`" + `;
+const icon = css` + "`" + `mask: url(./icon.svg#mask);` + "`" + `;`,
+			want: []string{"../images/hero.jpg", "./card.png", "./icon.svg"},
+		},
+		{
+			desc: "interpolated, external and untagged urls",
+			js: `const Avatar = styled.img` + "`" + `
+  background: url(${props => props.src}), url('https://example.com/bg.png');
+` + "`" + `;
+const notCss = ` + "`" + `url('./ignored.png')` + "`" + `;`,
+			want: nil,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestCSSInJsAssets")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, "styles.js"), []byte(tc.js), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got := cssInJsAssets(dir, "styles.js")
+
 			if !reflect.DeepEqual(got, tc.want) {
 				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
 			}
 		})
 	}
 }
+
+func TestMdxFileInfo(t *testing.T) {
+	mdx := `---
+title: Buttons
+---
+
+import { Button } from '../components/Button';
+import {
+  Card,
+} from "../components/Card";
+export const meta = { layout: 'docs' };
+
+# Buttons
+
+Use it like this, no need to import Button from 'elsewhere':
+
+` + "```jsx" + `
+import { Button } from '@acme/ui';
+
+<Button />
+` + "```" + `
+
+<Button>Click</Button>
+`
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestMdxFileInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "buttons.mdx"), []byte(mdx), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mdxFileinfo(dir, "buttons.mdx").Imports
+
+	if want := []string{"../components/Button", "../components/Card"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestUmdGlobals(t *testing.T) {
+	for _, tc := range []struct {
+		desc, name, js      string
+		globals, globalRefs []string
+	}{
+		{
+			desc: "declaration",
+			name: "jquery.d.ts",
+			js: `export = jQuery;
+export as namespace $;
+export as namespace jQuery;
+
+declare function jQuery(selector: string): JQuery;
+`,
+			globals: []string{"$", "jQuery"},
+		},
+		{
+			desc: "member accesses",
+			name: "app.ts",
+			js: `// $.comment() is ignored
+document.addEventListener('load', () => $.ajax('/api').then(res => console.log(res.data.items)));
+`,
+			globalRefs: []string{"$", "console", "document", "res"},
+		},
+		{
+			desc: "modules are not checked",
+			name: "module.ts",
+			js: `import { ready } from './ready';
+
+ready(() => $.ajax('/api'));
+`,
+		},
+		{
+			desc: "js files are not checked",
+			name: "app.js",
+			js:   `$.ajax('/api');`,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestUmdGlobals")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, tc.name), []byte(tc.js), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			info := jsFileinfo(dir, tc.name)
+
+			if !reflect.DeepEqual(info.Globals, tc.globals) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.Globals, tc.globals)
+			}
+			if !reflect.DeepEqual(info.GlobalRefs, tc.globalRefs) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", info.GlobalRefs, tc.globalRefs)
+			}
+		})
+	}
+}
@@ -17,10 +17,12 @@ package gazelle
 
 import (
 	"flag"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/bazelbuild/bazel-gazelle/testtools"
@@ -303,3 +305,2006 @@ ts_library(
 `,
 	}})
 }
+
+// runGazelle creates files in a temporary directory and runs the gazelle binary in it with args.
+func runGazelle(t *testing.T, files []testtools.FileSpec, args ...string) (string, func()) {
+	dir, cleanup := testtools.CreateFiles(t, files)
+
+	cmd := exec.Command(*gazellePath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return dir, cleanup
+}
+
+func TestGazelleBinaryBabelRuntime(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_babel_runtime @npm//@babel/runtime
+`},
+		{Path: "types/BUILD.bazel", Content: `
+# gazelle:js_ts_project_mode directory
+`},
+		{Path: "lib/async.js", Content: `
+import {format} from "date-fns";
+
+export default async function later() {
+  return format(new Date(), 'MM/DD/YYYY');
+}
+`},
+		{Path: "lib/sync.js", Content: `
+export default "sync";
+`},
+		{Path: "types/globals.d.ts", Content: `
+declare const VERSION: string;
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "async",
+    srcs = ["async.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "@npm//@babel/runtime",
+        "@npm//date-fns",
+    ],
+)
+
+js_library(
+    name = "sync",
+    srcs = ["sync.js"],
+    visibility = ["//visibility:public"],
+    deps = ["@npm//@babel/runtime"],
+)
+`,
+	}, {
+		Path: "types/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_declaration")
+
+# gazelle:js_ts_project_mode directory
+
+ts_declaration(
+    name = "globals.d",
+    srcs = ["globals.d.ts"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryTestAutoLibDep(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_test_auto_lib_dep true
+`},
+		{Path: "shared/sum.js", Content: `
+export default (a, b) => a + b;
+`},
+		{Path: "shared/sum.test.js", Content: `
+import { run } from '@test/harness';
+
+// The harness loads ./sum itself
+run('sum');
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-generate_js_tests")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "shared/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "jest_test", "js_library")
+
+js_library(
+    name = "sum",
+    srcs = ["sum.js"],
+    visibility = ["//visibility:public"],
+)
+
+jest_test(
+    name = "sum.test",
+    srcs = ["sum.test.js"],
+    deps = [
+        ":sum",
+        "@npm//@test/harness",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinarySharedFilegroup(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_shared_filegroup true
+`},
+		{Path: "lib/BUILD.bazel", Content: `
+filegroup(
+    name = "fixtures",
+    srcs = ["b.ts"],
+)
+`},
+		{Path: "lib/a.ts", Content: `
+import b from './b';
+`},
+		{Path: "lib/b.ts", Content: `
+export default "b";
+`},
+		{Path: "lib/c.js", Content: `
+export default "c";
+`},
+		{Path: "app/BUILD.bazel", Content: `
+# gazelle:js_ts_project_mode directory
+`},
+		{Path: "app/main.ts", Content: `
+import b from '../lib/b';
+`},
+		{Path: "app/util.ts", Content: `
+export default "util";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library", "ts_project")
+
+filegroup(
+    name = "fixtures",
+    srcs = ["b.ts"],
+)
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    visibility = ["//visibility:public"],
+    deps = [":b"],
+)
+
+ts_project(
+    name = "b",
+    srcs = ["b.ts"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "c",
+    srcs = ["c.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+# gazelle:js_ts_project_mode directory
+
+ts_project(
+    name = "app",
+    srcs = [":all_ts"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:b"],
+)
+
+filegroup(
+    name = "all_ts",
+    srcs = [
+        "main.ts",
+        "util.ts",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryTsProjectDirectoryMode(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_ts_project_mode directory
+`},
+		{Path: "lib/BUILD.bazel", Content: `
+load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    visibility = ["//visibility:public"],
+    deps = [":b"],
+)
+
+ts_project(
+    name = "b",
+    srcs = ["b.tsx"],
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/a.ts", Content: `
+import b from './b';
+import {format} from 'date-fns';
+`},
+		{Path: "lib/b.tsx", Content: `
+import React from 'react';
+`},
+		{Path: "lib/types.d.ts", Content: `
+declare type Id = string;
+`},
+		{Path: "lib/a.test.ts", Content: `
+import a from './a';
+`},
+		{Path: "types/globals.d.ts", Content: `
+declare const VERSION: string;
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "jest_test", "ts_project")
+
+jest_test(
+    name = "a.test",
+    srcs = ["a.test.ts"],
+    deps = [":lib"],
+)
+
+ts_project(
+    name = "lib",
+    srcs = [
+        "a.ts",
+        "b.tsx",
+        "types.d.ts",
+    ],
+    visibility = ["//visibility:public"],
+    deps = [
+        "@npm//date-fns",
+        "@npm//react",
+    ],
+)
+`,
+	}, {
+		Path: "types/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_declaration")
+
+ts_declaration(
+    name = "globals.d",
+    srcs = ["globals.d.ts"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryIndexBarrel(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/index.ts", Content: `
+export * from './a';
+export { b } from './b';
+export { default as c } from './c';
+export * from '.';
+`},
+		{Path: "lib/a.ts", Content: `
+export const a = "a";
+`},
+		{Path: "lib/b.ts", Content: `
+export const b = "b";
+`},
+		{Path: "lib/c.ts", Content: `
+export default "c";
+`},
+		{Path: "app/main.ts", Content: `
+import { a, b, c } from '../lib';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    visibility = ["//visibility:public"],
+)
+
+ts_project(
+    name = "b",
+    srcs = ["b.ts"],
+    visibility = ["//visibility:public"],
+)
+
+ts_project(
+    name = "c",
+    srcs = ["c.ts"],
+    visibility = ["//visibility:public"],
+)
+
+ts_project(
+    name = "index",
+    srcs = ["index.ts"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":a",
+        ":b",
+        ":c",
+    ],
+)
+`,
+	}, {
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "main",
+    srcs = ["main.ts"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:index"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryTsConfigTarget(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_ts_config_target //configs:tsconfig
+`},
+		{Path: "tsconfig.json", Content: `{}`},
+		{Path: "lib/tsconfig.json", Content: `{}`},
+		{Path: "lib/a.ts", Content: `
+export default "a";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    tsconfig = "//configs:tsconfig",
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryPlatformExtensions(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_platform_extensions ios,android,native
+`},
+		{Path: "ui/Button.ios.js", Content: `
+import { Platform } from 'react-native';
+`},
+		{Path: "ui/Button.android.js", Content: `
+import { ripple } from './ripple';
+`},
+		{Path: "ui/ripple.js", Content: `
+export const ripple = true;
+`},
+		{Path: "app/main.js", Content: `
+import Button from '../ui/Button';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "ui/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "Button",
+    srcs = [
+        "Button.android.js",
+        "Button.ios.js",
+    ],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":ripple",
+        "@npm//react-native",
+    ],
+)
+
+js_library(
+    name = "ripple",
+    srcs = ["ripple.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//ui:Button"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryCssAssets(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "styles/main.css", Content: `
+@font-face {
+  font-family: "Inter";
+  src: url('./inter.woff2') format("woff2"), url('./inter.woff') format("woff");
+}
+
+.hero {
+  background-image: image-set('./hero.png' 1x, './hero@2x.png' 2x);
+}
+`},
+		{Path: "styles/hero.png"},
+		{Path: "styles/hero@2x.png"},
+		{Path: "styles/inter.woff"},
+		{Path: "styles/inter.woff2"},
+	}
+	dir, cleanup := runGazelle(t, files, "-js_import_extensions", ".css")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "styles/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_import")
+
+js_import(
+    name = "main_css",
+    srcs = ["main.css"],
+    data = [
+        ":hero.png",
+        ":hero@2x.png",
+        ":inter.woff",
+        ":inter.woff2",
+    ],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryCSSModuleScripts(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "elements/card.js", Content: `
+import sheet from './card.css' with { type: 'css' };
+import config from './config.json' with { type: 'json' };
+
+document.adoptedStyleSheets = [sheet];
+`},
+		{Path: "elements/card.css", Content: `
+:host { display: block; }
+`},
+		{Path: "elements/config.json", Content: `{}`},
+		{Path: "elements/theme.js", Content: `
+import './card.css';
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-js_import_extensions", ".css,.json")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "elements/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_import", "js_library")
+
+js_import(
+    name = "card_css",
+    srcs = ["card.css"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "card",
+    srcs = ["card.js"],
+    data = [":config_json"],
+    visibility = ["//visibility:public"],
+    deps = [":card_css"],
+)
+
+js_import(
+    name = "config_json",
+    srcs = ["config.json"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "theme",
+    srcs = ["theme.js"],
+    data = [":card_css"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryConnectEs(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "proto/eliza/v1/BUILD.bazel", Content: `
+load("@aspect_rules_ts//ts:proto.bzl", "ts_proto_library")
+
+proto_library(
+    name = "eliza_proto",
+    srcs = ["eliza.proto"],
+)
+
+ts_proto_library(
+    name = "eliza_ts_proto",
+    node_modules = "//:node_modules",
+    proto = ":eliza_proto",
+)
+`},
+		{Path: "proto/eliza/v1/eliza.proto", Content: `
+syntax = "proto3";
+
+package eliza.v1;
+`},
+		{Path: "app/client.ts", Content: `
+import { createPromiseClient } from '@connectrpc/connect';
+import { ElizaService } from '../proto/eliza/v1/eliza_connect';
+import { SayRequest } from '../proto/eliza/v1/eliza_pb';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "client",
+    srcs = ["client.ts"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//proto/eliza/v1:eliza_ts_proto",
+        "@npm//@connectrpc/connect",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryNextJsStaticImages(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_nextjs true
+`},
+		{Path: "web/public/BUILD.bazel", Content: `
+exports_files(glob(["**"]))
+`},
+		{Path: "web/public/logo.png"},
+		{Path: "web/app/page.tsx", Content: `
+import Image from 'next/image';
+import Link from 'next/link';
+import logo from '../public/logo.png';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "web/app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "page",
+    srcs = ["page.tsx"],
+    data = ["//web/public:logo.png"],
+    visibility = ["//visibility:public"],
+    deps = ["@npm//next"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryInlineLoaders(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_inline_loader_deps true
+`},
+		{Path: "lib/main.js", Content: `
+import Worker from 'worker-loader!./worker.js';
+import text from '!!raw-loader?esModule=false!./notes';
+`},
+		{Path: "lib/notes.js", Content: `
+export default "notes";
+`},
+		{Path: "lib/worker.js", Content: `
+self.onmessage = () => {};
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":notes",
+        ":worker",
+        "@npm//raw-loader",
+        "@npm//worker-loader",
+    ],
+)
+
+js_library(
+    name = "notes",
+    srcs = ["notes.js"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "worker",
+    srcs = ["worker.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryPackageJSONTarget(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_package_json_target true
+`},
+		{Path: "tools/package.json", Content: `{"name": "tools", "version": "1.2.3"}`},
+		{Path: "tools/version.js", Content: `
+const { version } = require('./package.json');
+`},
+		{Path: "legacy/BUILD.bazel", Content: `
+filegroup(
+    name = "package_json",
+    srcs = ["package.json"],
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "legacy/index.js", Content: `
+export default "legacy";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "tools/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "version",
+    srcs = ["version.js"],
+    visibility = ["//visibility:public"],
+    deps = [":package_json"],
+)
+
+filegroup(
+    name = "package_json",
+    srcs = ["package.json"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "legacy/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryDepsAttr(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_deps_attr dependencies
+`},
+		{Path: "lib/a.js", Content: `
+import b from './b';
+import { format } from 'date-fns';
+`},
+		{Path: "lib/b.js", Content: `
+export default "b";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    dependencies = [
+        ":b",
+        "@npm//date-fns",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryDepsAttrNotRoot(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/BUILD.bazel", Content: `
+# gazelle:js_deps_attr dependencies
+`},
+		{Path: "lib/a.js", Content: `
+import b from './b';
+`},
+		{Path: "lib/b.js", Content: `
+export default "b";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+# gazelle:js_deps_attr dependencies
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    visibility = ["//visibility:public"],
+    deps = [":b"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryDepsAttrExisting(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_deps_attr dependencies
+`},
+		{Path: "lib/BUILD.bazel", Content: `
+load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    dependencies = [":b"],
+    visibility = ["//visibility:public"],
+    deps = ["@npm//moment"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/a.js", Content: `
+import { format } from 'date-fns';
+`},
+		{Path: "lib/b.js", Content: `
+export default "b";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    dependencies = ["@npm//date-fns"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryImportMetaResolve(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/pool.js", Content: `
+const workerURL = import.meta.resolve('./worker.js');
+export const pool = () => new Worker(workerURL, { type: 'module' });
+`},
+		{Path: "lib/worker.js", Content: `
+self.onmessage = () => {};
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "pool",
+    srcs = ["pool.js"],
+    visibility = ["//visibility:public"],
+    deps = [":worker"],
+)
+
+js_library(
+    name = "worker",
+    srcs = ["worker.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryExtensionlessFiles(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "bin/BUILD.bazel", Content: `# gazelle:js_extensionless_files cli=source,VERSION=asset`},
+		{Path: "bin/cli", Content: `#!/usr/bin/env node
+const run = require('./run');
+`},
+		{Path: "bin/Dockerfile", Content: `FROM node`},
+		{Path: "bin/VERSION", Content: `1.0.0`},
+		{Path: "bin/run.js", Content: `
+module.exports = () => {};
+`},
+		{Path: "lib/LICENSE", Content: `MIT`},
+		{Path: "lib/index.js", Content: `
+export default "lib";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "bin/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_import", "js_library")
+
+# gazelle:js_extensionless_files cli=source,VERSION=asset
+
+js_import(
+    name = "VERSION",
+    srcs = ["VERSION"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "cli",
+    srcs = ["cli"],
+    visibility = ["//visibility:public"],
+    deps = [":run"],
+)
+
+js_library(
+    name = "run",
+    srcs = ["run.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryPackageSubpaths(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "packages/kit/package.json", Content: `{
+    "name": "@acme/kit",
+    "exports": {
+        ".": "./src/index.js",
+        "./feature": "./src/feature/index.js",
+        "./utils": "./src/utils.js"
+    }
+}`},
+		{Path: "packages/kit/src/index.js", Content: `
+export * from './utils';
+`},
+		{Path: "packages/kit/src/utils.js", Content: `
+export const noop = () => {};
+`},
+		{Path: "packages/kit/src/feature/index.js", Content: `
+export const feature = true;
+`},
+		{Path: "app/main.js", Content: `
+import { feature } from '@acme/kit/feature';
+import { noop } from '@acme/kit/utils';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//packages/kit/src:utils",
+        "//packages/kit/src/feature:index",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryMapKind(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:map_kind js_library my_js_library //tools:defs.bzl
+`},
+		{Path: "lib/BUILD.bazel", Content: `
+load("//tools:defs.bzl", "my_js_library")
+
+my_js_library(
+    name = "c",
+    srcs = ["c.js"],
+    visibility = ["//visibility:public"],
+    deps = [":a"],
+)
+
+my_js_library(
+    name = "gone",
+    srcs = ["gone.js"],
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/a.js", Content: `
+import b from './b';
+import {format} from 'date-fns';
+`},
+		{Path: "lib/b.js", Content: `
+export default "b";
+`},
+		{Path: "lib/c.js", Content: `
+export default "c";
+`},
+		{Path: "app/BUILD.bazel"},
+		{Path: "app/main.js", Content: `
+import c from '../lib/c';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("//tools:defs.bzl", "my_js_library")
+
+my_js_library(
+    name = "c",
+    srcs = ["c.js"],
+    visibility = ["//visibility:public"],
+)
+
+my_js_library(
+    name = "a",
+    srcs = ["a.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":b",
+        "@npm//date-fns",
+    ],
+)
+
+my_js_library(
+    name = "b",
+    srcs = ["b.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "app/BUILD.bazel",
+		Content: `load("//tools:defs.bzl", "my_js_library")
+
+my_js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:c"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryHandMaintainedData(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/BUILD.bazel", Content: `
+load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    data = ["fixtures.json"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    data = [":old.bin"],
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/a.js", Content: `
+export default "a";
+`},
+		{Path: "lib/b.js", Content: `
+const url = new URL('./b.bin', import.meta.url);
+`},
+		{Path: "lib/b.bin"},
+		{Path: "lib/fixtures.json"},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "a",
+    srcs = ["a.js"],
+    data = ["fixtures.json"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "b",
+    srcs = ["b.js"],
+    data = [":b.bin"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryDuplicateRuleNames(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/foo.js", Content: `
+export const foo = "js";
+`},
+		{Path: "lib/foo.ts", Content: `
+export const foo: string = "ts";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library", "ts_project")
+
+js_library(
+    name = "foo",
+    srcs = ["foo.js"],
+    visibility = ["//visibility:public"],
+)
+
+ts_project(
+    name = "foo_ts",
+    srcs = ["foo.ts"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryMigrationPrefer(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_migration_prefer ts
+`},
+		{Path: "lib/foo.js", Content: `
+export const foo = "js";
+`},
+		{Path: "lib/foo.ts", Content: `
+export const foo: string = "ts";
+`},
+		{Path: "app/main.js", Content: `
+import { foo } from '../lib/foo';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:foo_ts"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryGoldenDir(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_golden_dir __golden__
+`},
+		{Path: "lib/render.js", Content: `
+export default function render() {}
+`},
+		{Path: "lib/render.test.js", Content: `
+import render from './render';
+`},
+		{Path: "lib/__golden__/render.html"},
+		{Path: "lib/__golden__/empty/render.html"},
+	}
+	dir, cleanup := runGazelle(t, files, "-generate_js_tests")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "jest_test", "js_library")
+
+js_library(
+    name = "render",
+    srcs = ["render.js"],
+    visibility = ["//visibility:public"],
+)
+
+jest_test(
+    name = "render.test",
+    srcs = ["render.test.js"],
+    data = [
+        ":__golden__/empty/render.html",
+        ":__golden__/render.html",
+    ],
+    deps = [":render"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryPersistImports(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_persist_imports true
+`},
+		{Path: "lib/foo.js", Content: `
+export const foo = "foo";
+`},
+		{Path: "app/main.js", Content: `
+import { foo } from '../lib/foo';
+import get from 'lodash/get';
+import { readFileSync } from 'fs';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	// A second run must read back the attribute and leave it unchanged
+	rerun := func() {
+		cmd := exec.Command(*gazellePath)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rerun()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    js_imports = {
+        "../lib/foo": "//lib:foo",
+        "fs": "",
+        "lodash/get": "@npm//lodash",
+    },
+    visibility = ["//visibility:public"],
+    deps = [
+        "//lib:foo",
+        "@npm//lodash",
+    ],
+)
+`,
+	}, {
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "foo",
+    srcs = ["foo.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+
+	// and replace it when the imports change
+	if err := ioutil.WriteFile(filepath.Join(dir, "app/main.js"), []byte("import get from 'lodash/get';\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rerun()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    js_imports = {
+        "lodash/get": "@npm//lodash",
+    },
+    visibility = ["//visibility:public"],
+    deps = ["@npm//lodash"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryMdx(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "components/Button.js", Content: `
+export const Button = () => null;
+`},
+		{Path: "docs/buttons.mdx", Content: `
+import { Button } from '../components/Button';
+
+# Buttons
+
+` + "```js" + `
+import { Button } from '@acme/ui';
+` + "```" + `
+
+<Button />
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "docs/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "mdx_library")
+
+mdx_library(
+    name = "buttons",
+    srcs = ["buttons.mdx"],
+    visibility = ["//visibility:public"],
+    deps = ["//components:Button"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryMdxKind(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:map_kind mdx_library docs_page //tools:docs.bzl
+`},
+		{Path: "docs/BUILD.bazel"},
+		{Path: "docs/intro.mdx", Content: `
+# Intro
+`},
+		{Path: "legacy/BUILD.bazel", Content: `
+load("//tools:docs.bzl", "docs_page")
+
+# gazelle:js_mdx_library false
+
+docs_page(
+    name = "old",
+    srcs = ["old.mdx"],
+    deps = ["//legacy/theme"],
+)
+`},
+		{Path: "legacy/old.mdx", Content: `
+# Old
+`},
+		{Path: "legacy/new.mdx", Content: `
+# New
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "docs/BUILD.bazel",
+		Content: `load("//tools:docs.bzl", "docs_page")
+
+docs_page(
+    name = "intro",
+    srcs = ["intro.mdx"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "legacy/BUILD.bazel",
+		Content: `load("//tools:docs.bzl", "docs_page")
+
+# gazelle:js_mdx_library false
+
+docs_page(
+    name = "old",
+    srcs = ["old.mdx"],
+    deps = ["//legacy/theme"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryCypressTest(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_cypress_test true
+`},
+		{Path: "web/cypress.config.js", Content: `
+module.exports = {};
+`},
+		{Path: "web/src/login.js", Content: `
+export const login = () => null;
+`},
+		{Path: "web/src/login.cy.ts", Content: `
+import { login } from './login';
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-generate_js_tests")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "web/src/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "cypress_test", "js_library")
+
+cypress_test(
+    name = "login.cy",
+    srcs = ["login.cy.ts"],
+    config = "//web:cypress.config",
+    deps = [":login"],
+)
+
+js_library(
+    name = "login",
+    srcs = ["login.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryCypressTestKind(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_cypress_test true
+# gazelle:map_kind cypress_test e2e_test @cypress//:defs.bzl
+`},
+		{Path: "web/cypress.config.js", Content: `
+module.exports = {};
+`},
+		{Path: "web/e2e/BUILD.bazel"},
+		{Path: "web/e2e/login.cy.ts", Content: `
+describe('login', () => {});
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-generate_js_tests")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "web/e2e/BUILD.bazel",
+		Content: `load("@cypress//:defs.bzl", "e2e_test")
+
+e2e_test(
+    name = "login.cy",
+    srcs = ["login.cy.ts"],
+    config = "//web:cypress.config",
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryWasmGlue(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "pkg/module.js", Content: `
+export default function init() {
+    return fetch(new URL('module_bg.wasm', import.meta.url));
+}
+`},
+		{Path: "pkg/module_bg.wasm"},
+		{Path: "app/index.js", Content: `
+import init from '../pkg/module';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "pkg/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "module",
+    srcs = ["module.js"],
+    data = [":module_bg.wasm"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//pkg:module"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryJestRuntimeDeps(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_jest_runtime_deps @npm//jest,@npm//@jest/globals,@npm//@types/jest
+`},
+		{Path: "shared/sum.js", Content: `
+export default (a, b) => a + b;
+`},
+		{Path: "shared/sum.test.js", Content: `
+import { expect, test } from '@jest/globals';
+import sum from './sum';
+
+test('sum', () => expect(sum(1, 2)).toBe(3));
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-generate_js_tests")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "shared/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "jest_test", "js_library")
+
+js_library(
+    name = "sum",
+    srcs = ["sum.js"],
+    visibility = ["//visibility:public"],
+)
+
+jest_test(
+    name = "sum.test",
+    srcs = ["sum.test.js"],
+    deps = [
+        ":sum",
+        "@npm//@jest/globals",
+        "@npm//@types/jest",
+        "@npm//jest",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryTsRootDirs(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "web/tsconfig.json", Content: `{
+    "compilerOptions": {
+        "rootDirs": ["src", "generated"]
+    }
+}`},
+		{Path: "web/generated/api.ts", Content: `
+export const fetchUser = () => null;
+`},
+		{Path: "web/src/client.ts", Content: `
+import { fetchUser } from './api';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "web/src/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "client",
+    srcs = ["client.ts"],
+    tsconfig = "//web:tsconfig.json",
+    visibility = ["//visibility:public"],
+    deps = ["//web/generated:api"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryTsValidate(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "tsconfig.json", Content: `{}`},
+		{Path: "lib/BUILD.bazel", Content: `
+load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+# gazelle:js_ts_validate false
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    tsconfig = "//:tsconfig.json",
+    validate = True,
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/a.ts", Content: `
+export default "a";
+`},
+		{Path: "lib/b.ts", Content: `
+export default "b";
+`},
+		{Path: "legacy/BUILD.bazel", Content: `
+load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "c",
+    srcs = ["c.ts"],
+    tsconfig = "//:tsconfig.json",
+    validate = False,  # keep
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "legacy/c.ts", Content: `
+export default "c";
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+# gazelle:js_ts_validate false
+
+ts_project(
+    name = "a",
+    srcs = ["a.ts"],
+    tsconfig = "//:tsconfig.json",
+    validate = False,
+    visibility = ["//visibility:public"],
+)
+
+ts_project(
+    name = "b",
+    srcs = ["b.ts"],
+    tsconfig = "//:tsconfig.json",
+    validate = False,
+    visibility = ["//visibility:public"],
+)
+`,
+	}, {
+		Path: "legacy/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "c",
+    srcs = ["c.ts"],
+    tsconfig = "//:tsconfig.json",
+    validate = False,  # keep
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryUmdGlobal(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "lib/legacy.d.ts", Content: `
+export = Legacy;
+export as namespace Legacy;
+
+declare namespace Legacy {
+    function greet(name: string): string;
+}
+`},
+		{Path: "app/greet.ts", Content: `
+const greeting = Legacy.greet('world');
+`},
+		{Path: "app/typed.ts", Content: `
+import * as Legacy from '../lib/legacy';
+
+export type Greet = typeof Legacy.greet;
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "ts_project")
+
+ts_project(
+    name = "greet",
+    srcs = ["greet.ts"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:legacy.d"],
+)
+
+ts_project(
+    name = "typed",
+    srcs = ["typed.ts"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib:legacy.d"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryOpaqueExtensions(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_opaque_extensions .bundle.js,.min.js
+`},
+		{Path: "lib/app.bundle.js", Content: `
+var React = require('react');
+import('./chunk-1234.js');
+`},
+		{Path: "lib/index.js", Content: `
+import './app.bundle';
+import { format } from 'date-fns';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "app.bundle",
+    srcs = ["app.bundle.js"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        ":app.bundle",
+        "@npm//date-fns",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryBrowserField(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "packages/socket/package.json", Content: `{
+    "name": "@acme/socket",
+    "browser": {
+        "ws": false,
+        "./server.js": "./client.js"
+    }
+}`},
+		{Path: "packages/socket/index.js", Content: `
+import WebSocket from 'ws';
+import { connect } from './server';
+`},
+		{Path: "packages/socket/server.js", Content: `
+import WebSocket from 'ws';
+`},
+		{Path: "packages/socket/client.js", Content: `
+export const connect = url => new window.WebSocket(url);
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "packages/socket/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "client",
+    srcs = ["client.js"],
+    visibility = ["//visibility:public"],
+)
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+    deps = [":client"],
+)
+
+js_library(
+    name = "server",
+    srcs = ["server.js"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryIndexAliases(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_index_aliases true
+`},
+		{Path: "lib/BUILD.bazel", Content: `
+alias(
+    name = "ui",
+    actual = "//lib/internal:button",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "kit",
+    actual = ":ui",
+    visibility = ["//visibility:public"],
+)
+`},
+		{Path: "lib/internal/button.js", Content: `
+export const Button = () => null;
+`},
+		{Path: "app/main.js", Content: `
+import { Button } from '../lib/ui';
+`},
+		{Path: "app/kit.js", Content: `
+import { Button } from '../lib/kit';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "kit",
+    srcs = ["kit.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib/internal:button"],
+)
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//lib/internal:button"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryEnforceFiles(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_enforce_files true
+`},
+		{Path: "packages/b/package.json", Content: `{
+    "name": "@acme/b",
+    "main": "lib/index.js",
+    "files": ["lib"]
+}`},
+		{Path: "packages/b/lib/index.js", Content: `
+export { secret } from '../internal/secret';
+`},
+		{Path: "packages/b/internal/secret.js", Content: `
+export const secret = 42;
+`},
+		{Path: "app/main.js", Content: `
+import b from '@acme/b';
+import { secret } from '@acme/b/internal/secret';
+`},
+	}
+	dir, cleanup := runGazelle(t, files)
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "app/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "main",
+    srcs = ["main.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//packages/b/lib:index"],
+)
+`,
+	}, {
+		Path: "packages/b/lib/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "index",
+    srcs = ["index.js"],
+    visibility = ["//visibility:public"],
+    deps = ["//packages/b/internal:secret"],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryStoryComponent(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "ui/index.js", Content: `
+export { Button } from './Button';
+export { Card } from './Card';
+`},
+		{Path: "ui/Button.js", Content: `
+export const Button = () => null;
+`},
+		{Path: "ui/Card.js", Content: `
+export const Card = () => null;
+`},
+		{Path: "stories/Button.stories.js", Content: `
+import { Button } from '../ui';
+
+export default {
+  title: 'Inputs/Button',
+  component: Button,
+};
+
+export const Primary = {};
+`},
+		{Path: "stories/Card.stories.js", Content: `
+import { Card as UiCard } from '@/ui';
+
+export default {
+  title: 'Surfaces/Card',
+  component: UiCard,
+};
+`},
+	}
+	dir, cleanup := runGazelle(t, files, "-alias_import_support")
+	defer cleanup()
+
+	testtools.CheckFiles(t, dir, []testtools.FileSpec{{
+		Path: "stories/BUILD.bazel",
+		Content: `load("@benchsci_test_tools_js//:defs.bzl", "js_library")
+
+js_library(
+    name = "Button.stories",
+    srcs = ["Button.stories.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//ui:Button",
+        "//ui:index",
+    ],
+)
+
+js_library(
+    name = "Card.stories",
+    srcs = ["Card.stories.js"],
+    visibility = ["//visibility:public"],
+    deps = [
+        "//ui:Card",
+        "//ui:index",
+    ],
+)
+`,
+	}})
+}
+
+func TestGazelleBinaryStrictResolution(t *testing.T) {
+	files := []testtools.FileSpec{
+		{Path: "WORKSPACE"},
+		{Path: "BUILD.bazel", Content: `
+# gazelle:js_strict_resolution true
+# gazelle:js_allow_unresolved virtual:*
+`},
+		{Path: "lib/a.js", Content: `
+import config from 'virtual:config';
+import missing from './missing';
+`},
+		{Path: "app/b.js", Content: `
+import gone from '../lib/gone';
+`},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	cmd := exec.Command(*gazellePath)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("gazelle succeeded with unresolved imports:\n%s", out)
+	}
+	for _, imp := range []string{"./missing", "../lib/gone"} {
+		if !strings.Contains(string(out), "import "+imp+" for ") {
+			t.Errorf("unresolved import %s not reported:\n%s", imp, out)
+		}
+	}
+	if strings.Contains(string(out), "import virtual:config for ") {
+		t.Errorf("allowed import virtual:config reported:\n%s", out)
+	}
+}
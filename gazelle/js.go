@@ -19,10 +19,15 @@ package gazelle
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
 )
@@ -31,27 +36,45 @@ var _ = fmt.Printf
 
 const extName = "js"
 
-type jslang struct{}
+type jslang struct {
+	// resolvers are consulted in order for imports not covered by a resolve directive.
+	resolvers []ImportResolver
+
+	// kinds are the kinds returned to gazelle, kept so that the attributes named by
+	// directives can be added to them once the directives are read.
+	kinds map[string]rule.KindInfo
+}
 
 // NewLanguage returns an instace of the Gazelle plugin for rules_sass.
-func NewLanguage() language.Language {
-	return &jslang{}
+// Custom resolvers may be passed to handle imports the built-in logic can't,
+// see ImportResolver.
+func NewLanguage(resolvers ...ImportResolver) language.Language {
+	return &jslang{resolvers: resolvers}
 }
 
 // Kinds returns a map of maps rule names (kinds) and information on how to
 // match and merge attributes that may be found in rules of those kinds. All
 // kinds of rules generated for this language may be found here.
 func (s *jslang) Kinds() map[string]rule.KindInfo {
-	return map[string]rule.KindInfo{
+	if s.kinds != nil {
+		return s.kinds
+	}
+	kinds := map[string]rule.KindInfo{
 		"js_library": {
 			MatchAny: false,
 			NonEmptyAttrs: map[string]bool{
 				"srcs": true,
 			},
+			// Gazelle can't merge the js_imports dict, so it is dropped from existing
+			// rules before resolving and set again afterwards as it is not resolved.
 			MergeableAttrs: map[string]bool{
-				"srcs": true,
+				"srcs":       true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps": true,
+				"data": true,
 			},
-			ResolveAttrs: map[string]bool{"deps": true},
 		},
 		"jest_test": {
 			MatchAny: false,
@@ -59,10 +82,12 @@ func (s *jslang) Kinds() map[string]rule.KindInfo {
 				"srcs": true,
 			},
 			MergeableAttrs: map[string]bool{
-				"srcs": true,
+				"srcs":       true,
+				"js_imports": true,
 			},
 			ResolveAttrs: map[string]bool{
 				"deps":   true,
+				"data":   true,
 				"config": true,
 			},
 		},
@@ -70,13 +95,15 @@ func (s *jslang) Kinds() map[string]rule.KindInfo {
 			MatchAny: false,
 			ResolveAttrs: map[string]bool{
 				"deps":   true,
+				"data":   true,
 				"config": true,
 			},
 			NonEmptyAttrs: map[string]bool{
 				"srcs": true,
 			},
 			MergeableAttrs: map[string]bool{
-				"srcs": true,
+				"srcs":       true,
+				"js_imports": true,
 			},
 		},
 		"ts_project": {
@@ -85,9 +112,15 @@ func (s *jslang) Kinds() map[string]rule.KindInfo {
 				"srcs": true,
 			},
 			MergeableAttrs: map[string]bool{
-				"srcs": true,
+				"srcs":       true,
+				"tsconfig":   true,
+				"validate":   true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps": true,
+				"data": true,
 			},
-			ResolveAttrs: map[string]bool{"deps": true},
 		},
 		"ts_library": {
 			MatchAny: false,
@@ -95,10 +128,88 @@ func (s *jslang) Kinds() map[string]rule.KindInfo {
 				"srcs": true,
 			},
 			MergeableAttrs: map[string]bool{
+				"srcs":       true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps": true,
+				"data": true,
+			},
+		},
+		"ts_declaration": {
+			MatchAny: false,
+			NonEmptyAttrs: map[string]bool{
 				"srcs": true,
 			},
-			ResolveAttrs: map[string]bool{"deps": true},
+			MergeableAttrs: map[string]bool{
+				"srcs":       true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps": true,
+				"data": true,
+			},
+		},
+		"cypress_test": {
+			MatchAny: false,
+			NonEmptyAttrs: map[string]bool{
+				"srcs": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"srcs":       true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps":   true,
+				"data":   true,
+				"config": true,
+			},
 		},
+		"mdx_library": {
+			MatchAny: false,
+			NonEmptyAttrs: map[string]bool{
+				"srcs": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"srcs":       true,
+				"js_imports": true,
+			},
+			ResolveAttrs: map[string]bool{
+				"deps": true,
+				"data": true,
+			},
+		},
+		"filegroup": {
+			MatchAny: false,
+			NonEmptyAttrs: map[string]bool{
+				"srcs": true,
+			},
+			MergeableAttrs: map[string]bool{
+				"srcs": true,
+			},
+		},
+		// Aliases are never generated, but indexed with js_index_aliases
+		"alias": {
+			MatchAny: false,
+		},
+		// Generated protobuf-es and connect-es modules are indexed, but never generated
+		"ts_proto_library": {
+			MatchAny: false,
+		},
+	}
+	s.kinds = kinds
+	return kinds
+}
+
+// addDepsAttr makes attr, set with js_deps_attr, one of the attributes gazelle replaces
+// in existing rules after resolving, next to deps. Gazelle asks for the kinds before
+// any directive is read, but keeps the attribute maps, so they are extended here. As
+// the maps apply to every package, the directive is only read in the root.
+func (s *jslang) addDepsAttr(attr string) {
+	for _, info := range s.Kinds() {
+		if info.ResolveAttrs["deps"] {
+			info.ResolveAttrs[attr] = true
+		}
 	}
 }
 
@@ -106,12 +217,13 @@ func (s *jslang) Kinds() map[string]rule.KindInfo {
 // GenerateRules, now or in the past, should be loadable from one of these
 // files.
 func (s *jslang) Loads() []rule.LoadInfo {
-	return []rule.LoadInfo{
+	loads := []rule.LoadInfo{
 		{
 			Name:    "@benchsci_test_tools_js//:defs.bzl",
-			Symbols: []string{"ts_library", "js_library", "ts_project", "jest_test", "js_import"},
+			Symbols: []string{"ts_library", "js_library", "ts_project", "ts_declaration", "jest_test", "js_import", "mdx_library", "cypress_test"},
 		},
 	}
+	return loads
 }
 
 func trimExt(filename string) string {
@@ -128,6 +240,15 @@ func containsSuffix(suffixes []string, x string) bool {
 	return false
 }
 
+func containsString(strs []string, x string) bool {
+	for _, s := range strs {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
+
 // GenerateRules extracts build metadata from source files in a directory.
 // GenerateRules is called in each directory where an update is requested
 // in depth-first post-order.
@@ -145,9 +266,14 @@ func containsSuffix(suffixes []string, x string) bool {
 func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
 	c := args.Config
 	js := GetJsConfig(c)
+	js.npmDeps.reset(c.RepoName, args.Rel)
 	// base is the last part of the path for this element. For example:
 	// "hello_world" => "hello_world"
 	// log.Println(args.OtherGen)
+	if js.GoldenDir != "" && hasPathSegment(args.Rel, js.GoldenDir) {
+		// Golden files are data of the tests next to the directory
+		return language.GenerateResult{}
+	}
 	base := path.Base(args.Rel)
 	if base == "." {
 		//args.Rel will return an empty string if you're in the root of the repo.
@@ -164,11 +290,36 @@ func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResu
 	empty := []*rule.Rule{}
 	var jsFiles []string
 	var jsImportFiles []string
+	// In directory mode all ts sources and declarations are collected into a single ts_project
+	var tsSrcs, dtsSrcs []string
+	var tsInfos, dtsInfos []FileInfo
+	// umdGlobals are the globals of each declaration, indexed without parsing it again
+	umdGlobals := make(map[string][]string)
 
 	// var normalFiles []string
 	for _, f := range append(args.RegularFiles, args.GenFiles...) {
 
-		base = (path.Base(f))
+		base := (path.Base(f))
+		if filepath.Ext(base) == "" {
+			// Files without an extension, like Dockerfile or LICENSE, only get rules when listed
+			switch js.ExtensionlessFiles[base] {
+			case extensionlessSource:
+				rule := rule.NewRule(js.JsLibrary.String(), base)
+				rule.SetAttr("srcs", []string{f})
+				rule.SetAttr("visibility", []string{"//visibility:public"})
+				rules = append(rules, rule)
+				imports = append(imports, jsFileinfo(args.Dir, f))
+				jsFiles = append(jsFiles, f)
+			case extensionlessAsset:
+				rule := rule.NewRule("js_import", base)
+				rule.SetAttr("srcs", []string{f})
+				rule.SetAttr("visibility", []string{"//visibility:public"})
+				rules = append(rules, rule)
+				imports = append(imports, FileInfo{})
+				jsImportFiles = append(jsImportFiles, f)
+			}
+			continue
+		}
 		prefix := trimExt(base)
 		base = strings.TrimSuffix(base, filepath.Ext(base))
 		if containsSuffix(js.JsImportExtenstions, f) {
@@ -177,8 +328,21 @@ func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResu
 			// TODO: Ideally we would not just apply public visibility
 			rule.SetAttr("visibility", []string{"//visibility:public"})
 			rules = append(rules, rule)
-			slice := []string{}
-			imports = append(imports, slice)
+			if containsSuffix(styleExtensions, f) {
+				imports = append(imports, cssFileinfo(args.Dir, f))
+			} else {
+				imports = append(imports, FileInfo{})
+			}
+		}
+		if js.MdxLibrary && strings.HasSuffix(f, ".mdx") {
+			rule := rule.NewRule("mdx_library", base)
+			rule.SetAttr("srcs", []string{f})
+			// TODO: Ideally we would not just apply public visibility
+			rule.SetAttr("visibility", []string{"//visibility:public"})
+			rules = append(rules, rule)
+			imports = append(imports, mdxFileinfo(args.Dir, f))
+			jsFiles = append(jsFiles, f)
+			continue
 		}
 		// Only generate js entries for known js files (.vue/.js) - can probably be extended
 		if (!strings.HasSuffix(f, ".vue") && !strings.HasSuffix(f, ".js") && !strings.HasSuffix(f, ".jsx") && !strings.HasSuffix(f, ".tsx") && !strings.HasSuffix(f, ".ts")) ||
@@ -189,12 +353,60 @@ func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResu
 			continue
 		}
 
-		fileInfo := jsFileinfo(args.Dir, f)
-		imports = append(imports, fileInfo.Imports)
 		jsFiles = append(jsFiles, f)
+		if containsSuffix(js.OpaqueExtensions, f) {
+			// Bundles and the like are taken as they are, their imports are not dependencies
+			imports = append(imports, FileInfo{Path: filepath.Join(args.Dir, f), Name: f})
+			rule := rule.NewRule(js.JsLibrary.String(), base)
+			rule.SetAttr("srcs", []string{f})
+			rule.SetAttr("visibility", []string{"//visibility:public"})
+			rules = append(rules, rule)
+			continue
+		}
 
+		fileInfo := jsFileinfo(args.Dir, f)
+		if strings.HasSuffix(f, ".d.ts") {
+			umdGlobals[f] = fileInfo.Globals
+		}
+		if js.DetectAssetAssignments {
+			if assets := assetAssignments(args.Dir, f); len(assets) > 0 {
+				fileInfo.Data = append(append([]string{}, fileInfo.Data...), assets...)
+				sort.Strings(fileInfo.Data)
+			}
+		}
+		if js.CSSInJs {
+			if assets := cssInJsAssets(args.Dir, f); len(assets) > 0 {
+				fileInfo.Data = append(append([]string{}, fileInfo.Data...), assets...)
+				sort.Strings(fileInfo.Data)
+			}
+		}
+		if wasm := wasmBindingFile(f, args.RegularFiles); wasm != "" {
+			// The glue loads the module at runtime, so it is shipped as data instead of an import
+			fileInfo = withWasmBinding(fileInfo, "./"+wasm)
+		}
+
+		if js.CypressTest && containsSuffix(cypressExtensions, f) {
+			rule := rule.NewRule("cypress_test", base)
+			rule.SetAttr("srcs", []string{f})
+			rules = append(rules, rule)
+			imports = append(imports, fileInfo)
+			continue
+		}
 
 		var test_extensions = []string{".test.js", ".test.jsx", ".test.tsx"}
+		if js.TsProjectMode == TsProjectDirectoryMode && !containsSuffix(test_extensions, f) && !strings.HasSuffix(f, "test.ts") {
+			if strings.HasSuffix(f, ".d.ts") {
+				dtsSrcs = append(dtsSrcs, f)
+				dtsInfos = append(dtsInfos, fileInfo)
+				continue
+			} else if strings.HasSuffix(f, ".ts") || strings.HasSuffix(f, ".tsx") {
+				tsSrcs = append(tsSrcs, f)
+				tsInfos = append(tsInfos, fileInfo)
+				continue
+			}
+		}
+		imports = append(imports, fileInfo)
+
 		if containsSuffix(test_extensions, f) {
 			rule := rule.NewRule("jest_test", base)
 			rule.SetAttr("srcs", []string{f})
@@ -237,10 +449,101 @@ func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResu
 		}
 	}
 
-	empty = append(empty, generateEmpty(args.File, jsFiles, map[string]bool{js.JsLibrary.String(): true, "jest_test": true, "ts_library": true})...)
+	if len(js.PlatformExtensions) > 0 {
+		rules, imports = groupPlatformVariants(rules, imports, js)
+	}
+
+	if len(tsSrcs) > 0 {
+		// Declarations are inputs for the type checking of the sources next to them
+		srcs := append(tsSrcs, dtsSrcs...)
+		rule := rule.NewRule("ts_project", base)
+		rule.SetAttr("srcs", srcs)
+		// TODO: Ideally we would not just apply public visibility
+		rule.SetAttr("visibility", []string{"//visibility:public"})
+		rules = append(rules, rule)
+		imports = append(imports, mergeFileInfos(append(tsInfos, dtsInfos...)))
+		empty = append(empty, generateEmptySuperseded(args.Config, args.File, base, srcs)...)
+	} else {
+		// Directories with only declarations get a ts_declaration per declaration
+		for i, f := range dtsSrcs {
+			rule := rule.NewRule("ts_declaration", strings.TrimSuffix(f, filepath.Ext(f)))
+			rule.SetAttr("srcs", []string{f})
+			rules = append(rules, rule)
+			imports = append(imports, dtsInfos[i])
+		}
+	}
+
+	if js.GoldenDir != "" {
+		if golden := goldenFiles(args.Dir, js.GoldenDir); len(golden) > 0 {
+			for i, r := range rules {
+				if r.Kind() == "jest_test" {
+					info := imports[i].(FileInfo)
+					info.Data = append(append([]string{}, info.Data...), golden...)
+					imports[i] = info
+				}
+			}
+		}
+	}
+
+	dedupeRuleNames(args.Rel, rules)
+
+	if tsconfig := js.tsConfigLabel(); tsconfig != label.NoLabel {
+		for _, r := range rules {
+			if r.Kind() == "ts_project" {
+				r.SetAttr("tsconfig", tsconfig.Rel("", args.Rel).String())
+			}
+		}
+	}
+	if js.TsValidate != nil {
+		for _, r := range rules {
+			if r.Kind() == "ts_project" {
+				r.SetAttr("validate", *js.TsValidate)
+			}
+		}
+	}
+
+	if js.PackageJSONTarget && containsString(args.RegularFiles, "package.json") {
+		rule := rule.NewRule("filegroup", packageJSONTarget)
+		rule.SetAttr("srcs", []string{"package.json"})
+		rule.SetAttr("visibility", []string{"//visibility:public"})
+		rules = append(rules, rule)
+		imports = append(imports, FileInfo{})
+	} else if !containsString(args.RegularFiles, "package.json") {
+		empty = append(empty, generateEmptyPackageJSONTarget(args.File)...)
+	}
+
+	if js.SharedFilegroup {
+		filegroups := generateSharedFilegroups(rules, jsFiles)
+		for _, fg := range filegroups {
+			rules = append(rules, fg)
+			imports = append(imports, FileInfo{})
+		}
+		empty = append(empty, generateEmptyFilegroups(args.File, filegroups)...)
+	} else {
+		empty = append(empty, generateEmptyFilegroups(args.File, nil)...)
+	}
+
+	// Without js_mdx_library the mdx_library rules are maintained by hand
+	knownRuleKinds := map[string]bool{js.JsLibrary.String(): true, "jest_test": true, "ts_library": true, "ts_declaration": true, "mdx_library": js.MdxLibrary, "cypress_test": true}
+	empty = append(empty, generateEmpty(args.Config, args.File, jsFiles, knownRuleKinds)...)
 
 	if len(js.JsImportExtenstions) > 0 {
-		empty = append(empty, generateEmpty(args.File, jsImportFiles, map[string]bool{"js_import": true})...)
+		empty = append(empty, generateEmpty(args.Config, args.File, jsImportFiles, map[string]bool{"js_import": true})...)
+	}
+
+	keepExistingData(args.Config, args.File, rules)
+	if len(umdGlobals) > 0 {
+		for _, r := range rules {
+			r.SetPrivateAttr(umdGlobalsKey, umdGlobals)
+		}
+	}
+	if js.pendingRules != nil {
+		for _, r := range rules {
+			// Filegroups are not resolved
+			if r.Kind() != "filegroup" {
+				*js.pendingRules++
+			}
+		}
 	}
 
 	return language.GenerateResult{
@@ -255,10 +558,345 @@ func (s *jslang) GenerateRules(args language.GenerateArgs) language.GenerateResu
 	}
 }
 
+// mergeFileInfos combines the imports and data of several files that are part of the same rule.
+func mergeFileInfos(infos []FileInfo) FileInfo {
+	var merged FileInfo
+	imports := make(map[string]bool)
+	typeImports := make(map[string]bool)
+	data := make(map[string]bool)
+	globalRefs := make(map[string]bool)
+	storyComponents := make(map[string]bool)
+	// An import only needs declarations if none of the files imports it as a value
+	valueImports := make(map[string]bool)
+	declarationImports := make(map[string]bool)
+	for _, info := range infos {
+		declarations := make(map[string]bool)
+		for _, imp := range info.DeclarationImports {
+			declarations[imp] = true
+			declarationImports[imp] = true
+		}
+		for _, imp := range info.Imports {
+			if !imports[imp] {
+				imports[imp] = true
+				merged.Imports = append(merged.Imports, imp)
+			}
+			if !declarations[imp] {
+				valueImports[imp] = true
+			}
+		}
+		for _, imp := range info.TypeImports {
+			if !typeImports[imp] {
+				typeImports[imp] = true
+				merged.TypeImports = append(merged.TypeImports, imp)
+			}
+		}
+		for _, datum := range info.Data {
+			if !data[datum] {
+				data[datum] = true
+				merged.Data = append(merged.Data, datum)
+			}
+		}
+		for _, ref := range info.GlobalRefs {
+			if !globalRefs[ref] {
+				globalRefs[ref] = true
+				merged.GlobalRefs = append(merged.GlobalRefs, ref)
+			}
+		}
+		for _, component := range info.StoryComponents {
+			if !storyComponents[component] {
+				storyComponents[component] = true
+				merged.StoryComponents = append(merged.StoryComponents, component)
+			}
+		}
+	}
+	for imp := range declarationImports {
+		if !valueImports[imp] {
+			merged.DeclarationImports = append(merged.DeclarationImports, imp)
+		}
+	}
+	sort.Strings(merged.Imports)
+	sort.Strings(merged.TypeImports)
+	sort.Strings(merged.DeclarationImports)
+	sort.Strings(merged.Data)
+	sort.Strings(merged.GlobalRefs)
+	sort.Strings(merged.StoryComponents)
+	return merged
+}
+
+// sharedFilegroupPrefix is the name prefix of the filegroups generated for js_shared_filegroup,
+// followed by the extension of the files they contain, e.g. all_ts.
+const sharedFilegroupPrefix = "all_"
+
+// generateSharedFilegroups hoists the source files of each extension into a filegroup, if
+// there is more than one of them, and makes rules with exactly these srcs refer to it instead.
+// Only the filegroups some rule refers to are returned.
+func generateSharedFilegroups(rules []*rule.Rule, files []string) []*rule.Rule {
+	byExt := make(map[string][]string)
+	for _, f := range files {
+		ext := strings.TrimPrefix(filepath.Ext(f), ".")
+		byExt[ext] = append(byExt[ext], f)
+	}
+	exts := make([]string, 0, len(byExt))
+	for ext := range byExt {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	var filegroups []*rule.Rule
+	for _, ext := range exts {
+		srcs := byExt[ext]
+		if len(srcs) < 2 {
+			continue
+		}
+		sort.Strings(srcs)
+		fg := rule.NewRule("filegroup", sharedFilegroupPrefix+ext)
+		fg.SetAttr("srcs", srcs)
+		referenced := false
+		for _, r := range rules {
+			ruleSrcs := append([]string{}, r.AttrStrings("srcs")...)
+			sort.Strings(ruleSrcs)
+			if reflect.DeepEqual(ruleSrcs, srcs) {
+				r.SetAttr("srcs", []string{":" + fg.Name()})
+				referenced = true
+			}
+		}
+		if referenced {
+			filegroups = append(filegroups, fg)
+		}
+	}
+	return filegroups
+}
+
+// wasmBindingFile returns the WebAssembly module wasm-pack generates next to the js glue f,
+// e.g. module_bg.wasm for module.js, or "" if there is none.
+func wasmBindingFile(f string, files []string) string {
+	if !strings.HasSuffix(f, ".js") {
+		return ""
+	}
+	wasm := strings.TrimSuffix(f, ".js") + "_bg.wasm"
+	for _, file := range files {
+		if file == wasm {
+			return wasm
+		}
+	}
+	return ""
+}
+
+// withWasmBinding moves the WebAssembly module wasm of the glue described by info from
+// its imports to its data.
+func withWasmBinding(info FileInfo, wasm string) FileInfo {
+	imports := []string{}
+	for _, imp := range info.Imports {
+		if imp != wasm {
+			imports = append(imports, imp)
+		}
+	}
+	info.Imports = imports
+	info.Data = append(append([]string{}, info.Data...), wasm)
+	sort.Strings(info.Data)
+	return info
+}
+
+// cypressExtensions are the suffixes of Cypress component and e2e specs.
+var cypressExtensions = []string{".cy.js", ".cy.jsx", ".cy.ts", ".cy.tsx"}
+
+// hasPathSegment reports whether one of the directories of rel is named segment.
+func hasPathSegment(rel, segment string) bool {
+	for _, s := range strings.Split(rel, "/") {
+		if s == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// goldenFiles returns labels for the files in the golden directory of dir, which belong
+// to the package of dir, e.g. :__golden__/render.html.
+func goldenFiles(dir, goldenDir string) []string {
+	root := filepath.Join(dir, goldenDir)
+	var files []string
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files = append(files, ":"+filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// dedupeRuleNames renames rules that would otherwise share a name with an earlier rule
+// of the package, e.g. for foo.js and foo.ts, by suffixing the extension of their first
+// source the way js_import rules are named. The conflict is logged, as the rule is
+// likely better renamed or its sources split up by hand.
+func dedupeRuleNames(rel string, rules []*rule.Rule) {
+	names := make(map[string]*rule.Rule)
+	for _, r := range rules {
+		names[r.Name()] = r
+	}
+	seen := make(map[string]*rule.Rule)
+	for _, r := range rules {
+		first, ok := seen[r.Name()]
+		if !ok {
+			seen[r.Name()] = r
+			continue
+		}
+		srcs := r.AttrStrings("srcs")
+		if len(srcs) == 0 {
+			continue
+		}
+		name := r.Name() + trimExt(srcs[0])
+		for i := 2; names[name] != nil; i++ {
+			name = fmt.Sprintf("%s%s_%d", r.Name(), trimExt(srcs[0]), i)
+		}
+		log.Printf("%s: rules for %s and %s are both named %s, naming the one for %s %s", rel,
+			strings.Join(first.AttrStrings("srcs"), ", "), strings.Join(srcs, ", "), r.Name(), srcs[0], name)
+		r.SetName(name)
+		names[name] = r
+		seen[name] = r
+	}
+}
+
+// platformBase removes a platform extension from name, e.g. Button.ios becomes Button.
+func platformBase(name string, platforms []string) string {
+	ext := path.Ext(name)
+	for _, platform := range platforms {
+		if ext == "."+platform {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// groupPlatformVariants merges the libraries of platform variants like Button.ios.js and
+// Button.android.js, and the default Button.js if there is one, into a single rule named
+// Button. imports holds the FileInfo of each rule and is merged alongside.
+func groupPlatformVariants(rules []*rule.Rule, imports []interface{}, js *JsConfig) ([]*rule.Rule, []interface{}) {
+	groups := make(map[string]int)
+	var grouped []*rule.Rule
+	var groupedImports []interface{}
+	for i, r := range rules {
+		if r.Kind() != js.JsLibrary.String() && r.Kind() != "ts_project" {
+			grouped = append(grouped, r)
+			groupedImports = append(groupedImports, imports[i])
+			continue
+		}
+		name := platformBase(r.Name(), js.PlatformExtensions)
+		key := r.Kind() + ":" + name
+		j, ok := groups[key]
+		if !ok {
+			r.SetName(name)
+			groups[key] = len(grouped)
+			grouped = append(grouped, r)
+			groupedImports = append(groupedImports, imports[i])
+			continue
+		}
+		srcs := append(grouped[j].AttrStrings("srcs"), r.AttrStrings("srcs")...)
+		sort.Strings(srcs)
+		grouped[j].SetAttr("srcs", srcs)
+		groupedImports[j] = mergeFileInfos([]FileInfo{groupedImports[j].(FileInfo), imports[i].(FileInfo)})
+	}
+	return grouped, groupedImports
+}
+
+// isSharedFilegroup reports whether r is a filegroup generated for js_shared_filegroup.
+func isSharedFilegroup(r *rule.Rule) bool {
+	return r.Kind() == "filegroup" && strings.HasPrefix(r.Name(), sharedFilegroupPrefix)
+}
+
+// packageJSONTarget is the name of the filegroup generated for the package.json of a
+// directory with js_package_json_target.
+const packageJSONTarget = "package_json"
+
+// isPackageJSONTarget reports whether r is a filegroup generated for js_package_json_target.
+func isPackageJSONTarget(r *rule.Rule) bool {
+	return r.Kind() == "filegroup" && r.Name() == packageJSONTarget
+}
+
+// generateEmptyPackageJSONTarget returns the existing package.json filegroup in f, which
+// is deleted once the package.json is gone.
+func generateEmptyPackageJSONTarget(f *rule.File) []*rule.Rule {
+	if f == nil {
+		return nil
+	}
+	for _, r := range f.Rules {
+		if isPackageJSONTarget(r) {
+			return []*rule.Rule{rule.NewRule(r.Kind(), r.Name())}
+		}
+	}
+	return nil
+}
+
+// generateEmptyFilegroups returns the existing shared filegroups in f that are not
+// among the ones generated this time, so they will be deleted.
+func generateEmptyFilegroups(f *rule.File, filegroups []*rule.Rule) []*rule.Rule {
+	if f == nil {
+		return nil
+	}
+	generated := make(map[string]bool)
+	for _, fg := range filegroups {
+		generated[fg.Name()] = true
+	}
+	var empty []*rule.Rule
+	for _, r := range f.Rules {
+		if isSharedFilegroup(r) && !generated[r.Name()] {
+			empty = append(empty, rule.NewRule(r.Kind(), r.Name()))
+		}
+	}
+	return empty
+}
+
+// ruleSrcs returns the srcs of r, expanding references to shared filegroups in f.
+func ruleSrcs(r *rule.Rule, f *rule.File) []string {
+	var srcs []string
+	for _, src := range r.AttrStrings("srcs") {
+		if strings.HasPrefix(src, ":"+sharedFilegroupPrefix) && f != nil {
+			for _, fg := range f.Rules {
+				if isSharedFilegroup(fg) && ":"+fg.Name() == src {
+					srcs = append(srcs, fg.AttrStrings("srcs")...)
+				}
+			}
+			continue
+		}
+		srcs = append(srcs, src)
+	}
+	return srcs
+}
+
+// existingDataKey is the private attribute holding the data of the existing rule a
+// generated one is merged with.
+const existingDataKey = "_js_existing_data"
+
+// keepExistingData records the data of the existing rules the generated ones replace,
+// so Resolve can leave it untouched when it finds no assets of its own.
+func keepExistingData(c *config.Config, f *rule.File, gen []*rule.Rule) {
+	for _, r := range gen {
+		if old := existingRule(c, f, r); old != nil && old.Attr("data") != nil {
+			r.SetPrivateAttr(existingDataKey, old.Attr("data"))
+		}
+	}
+}
+
+// existingRule returns the rule of f the generated rule r is merged with, if any.
+func existingRule(c *config.Config, f *rule.File, r *rule.Rule) *rule.Rule {
+	if f == nil {
+		return nil
+	}
+	for _, old := range f.Rules {
+		if old.Name() == r.Name() && ruleKind(c, old) == r.Kind() {
+			return old
+		}
+	}
+	return nil
+}
+
 // generateEmpty generates a list of jest_test, js_library and js_import rules that may be
 // deleted. This is generated from these existing rules with srcs lists that don't match any
-// static or generated files.
-func generateEmpty(f *rule.File, files []string, knownRuleKinds map[string]bool) []*rule.Rule {
+// static or generated files. Rules whose kind was changed with map_kind are recognised
+// by their original kind, but keep their kind so gazelle matches them.
+func generateEmpty(c *config.Config, f *rule.File, files []string, knownRuleKinds map[string]bool) []*rule.Rule {
 	if f == nil {
 		return nil
 	}
@@ -269,7 +907,8 @@ func generateEmpty(f *rule.File, files []string, knownRuleKinds map[string]bool)
 	var empty []*rule.Rule
 outer:
 	for _, r := range f.Rules {
-		if !knownRuleKinds[r.Kind()] {
+		kind := ruleKind(c, r)
+		if !knownRuleKinds[kind] {
 			continue
 		}
 		srcs := r.AttrStrings("srcs")
@@ -277,7 +916,7 @@ outer:
 			// srcs is not a string list; leave it alone
 			continue
 		}
-		for _, src := range r.AttrStrings("srcs") {
+		for _, src := range ruleSrcs(r, f) {
 			if knownFiles[src] {
 				continue outer
 			}
@@ -287,6 +926,38 @@ outer:
 	return empty
 }
 
+// generateEmptySuperseded returns the existing TypeScript rules in f whose sources are all
+// part of the ts_project name of directory mode, like the per-file rules generated before
+// switching to it, so they will be deleted.
+func generateEmptySuperseded(c *config.Config, f *rule.File, name string, srcs []string) []*rule.Rule {
+	if f == nil {
+		return nil
+	}
+	included := make(map[string]bool)
+	for _, src := range srcs {
+		included[src] = true
+	}
+	var empty []*rule.Rule
+outer:
+	for _, r := range f.Rules {
+		kind := ruleKind(c, r)
+		if r.Name() == name || (kind != "ts_project" && kind != "ts_library" && kind != "ts_declaration") {
+			continue
+		}
+		ruleSrcs := ruleSrcs(r, f)
+		if len(ruleSrcs) == 0 {
+			continue
+		}
+		for _, src := range ruleSrcs {
+			if !included[src] {
+				continue outer
+			}
+		}
+		empty = append(empty, rule.NewRule(r.Kind(), r.Name()))
+	}
+	return empty
+}
+
 // Fix repairs deprecated usage of language-specific rules in f. This is
 // called before the file is indexed. Unless c.ShouldFix is true, fixes
 // that delete or rename rules should not be performed.
@@ -0,0 +1,137 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func TestGenerateSharedFilegroups(t *testing.T) {
+	shared := rule.NewRule("ts_project", "pkg")
+	shared.SetAttr("srcs", []string{"b.ts", "a.ts"})
+	single := rule.NewRule("ts_project", "a")
+	single.SetAttr("srcs", []string{"a.ts"})
+	lib := rule.NewRule("js_library", "c")
+	lib.SetAttr("srcs", []string{"c.js"})
+
+	filegroups := generateSharedFilegroups([]*rule.Rule{shared, single, lib}, []string{"a.ts", "b.ts", "c.js"})
+
+	if len(filegroups) != 1 || filegroups[0].Name() != "all_ts" {
+		t.Fatalf("got filegroups %v; want only all_ts", filegroups)
+	}
+	if got, want := filegroups[0].AttrStrings("srcs"), []string{"a.ts", "b.ts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all_ts srcs: got %#v; want %#v", got, want)
+	}
+	for _, tc := range []struct {
+		r    *rule.Rule
+		want []string
+	}{
+		{r: shared, want: []string{":all_ts"}},
+		{r: single, want: []string{"a.ts"}},
+		{r: lib, want: []string{"c.js"}},
+	} {
+		if got := tc.r.AttrStrings("srcs"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s srcs: got %#v; want %#v", tc.r.Name(), got, tc.want)
+		}
+	}
+
+	f := &rule.File{Rules: append(filegroups, shared)}
+	if got, want := ruleSrcs(shared, f), []string{"a.ts", "b.ts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expanded srcs: got %#v; want %#v", got, want)
+	}
+
+	// Filegroups no rule refers to, like in file mode, are not generated
+	if filegroups := generateSharedFilegroups([]*rule.Rule{single, lib}, []string{"a.ts", "b.ts", "c.js"}); len(filegroups) != 0 {
+		t.Errorf("got filegroups %v; want none", filegroups)
+	}
+}
+
+func TestGenerateEmptyMappedKind(t *testing.T) {
+	mapped := rule.NewRule("my_js_library", "gone")
+	mapped.SetAttr("srcs", []string{"gone.js"})
+	kept := rule.NewRule("my_js_library", "kept")
+	kept.SetAttr("srcs", []string{"kept.js"})
+	other := rule.NewRule("my_macro", "other")
+	other.SetAttr("srcs", []string{"other.js"})
+	f := &rule.File{Rules: []*rule.Rule{mapped, kept, other}}
+	c := config.New()
+	c.KindMap = map[string]config.MappedKind{
+		"js_library": {FromKind: "js_library", KindName: "my_js_library", KindLoad: "//tools:defs.bzl"},
+	}
+
+	empty := generateEmpty(c, f, []string{"kept.js"}, map[string]bool{"js_library": true})
+
+	if len(empty) != 1 || empty[0].Name() != "gone" || empty[0].Kind() != "my_js_library" {
+		t.Errorf("got empty rules %v; want only my_js_library gone", empty)
+	}
+}
+
+func TestGroupPlatformVariants(t *testing.T) {
+	js := &JsConfig{JsLibrary: JsLibrary, PlatformExtensions: []string{"ios", "android"}}
+	var rules []*rule.Rule
+	for _, src := range []string{"Button.android.js", "Button.ios.js", "Button.js", "Button.web.js"} {
+		r := rule.NewRule("js_library", strings.TrimSuffix(src, ".js"))
+		r.SetAttr("srcs", []string{src})
+		rules = append(rules, r)
+	}
+	imports := []interface{}{
+		FileInfo{Imports: []string{"./ripple"}},
+		FileInfo{Imports: []string{"react-native"}},
+		FileInfo{},
+		FileInfo{},
+	}
+
+	grouped, groupedImports := groupPlatformVariants(rules, imports, js)
+
+	if len(grouped) != 2 || grouped[0].Name() != "Button" || grouped[1].Name() != "Button.web" {
+		t.Fatalf("got rules %v; want Button and Button.web", grouped)
+	}
+	if got, want := grouped[0].AttrStrings("srcs"), []string{"Button.android.js", "Button.ios.js", "Button.js"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Button srcs: got %#v; want %#v", got, want)
+	}
+	if got, want := groupedImports[0].(FileInfo).Imports, []string{"./ripple", "react-native"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Button imports: got %#v; want %#v", got, want)
+	}
+}
+
+func TestDedupeRuleNames(t *testing.T) {
+	var rules []*rule.Rule
+	for _, r := range []struct{ kind, name, src string }{
+		{"js_library", "foo", "foo.js"},
+		{"ts_project", "foo", "foo.ts"},
+		{"js_import", "foo_ts", "foo_ts.svg"},
+		{"jest_test", "foo.test", "foo.test.js"},
+	} {
+		gen := rule.NewRule(r.kind, r.name)
+		gen.SetAttr("srcs", []string{r.src})
+		rules = append(rules, gen)
+	}
+
+	dedupeRuleNames("pkg", rules)
+
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.Name())
+	}
+	if want := []string{"foo", "foo_ts_2", "foo_ts", "foo.test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
@@ -0,0 +1,325 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packageJSON is the subset of a first-party package.json relevant to import resolution.
+type packageJSON struct {
+	Name    string      `json:"name"`
+	Main    string      `json:"main"`
+	Exports interface{} `json:"exports"`
+	// Browser replaces the main file, or maps modules and files to replacements or
+	// false for builds targeting browsers.
+	Browser interface{} `json:"browser"`
+	// Files lists the files and directories published by the package, all if it is empty.
+	Files []string `json:"files"`
+
+	// Rel is the directory of the package.json relative to the repository root.
+	Rel string `json:"-"`
+}
+
+// exportConditions are the conditions of a conditional export that are tried, in order.
+var exportConditions = []string{"import", "require", "default"}
+
+// loadPackageJSON reads the package.json in dir, if there is one. rel is the
+// slash-separated path of dir relative to the repository root.
+func loadPackageJSON(dir, rel string) (*packageJSON, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pkg := &packageJSON{Rel: rel}
+	if err := json.Unmarshal(content, pkg); err != nil {
+		return nil, fmt.Errorf("%s: error parsing package.json: %v", path.Join(rel, "package.json"), err)
+	}
+	return pkg, nil
+}
+
+// resolveSubpath maps a subpath of the package, "." for the package itself or
+// "./some/file" otherwise, to a path relative to the repository root. An empty
+// string is returned if the package does not export the subpath.
+func (pkg *packageJSON) resolveSubpath(subpath string) string {
+	if pkg.Exports == nil {
+		if subpath == "." {
+			if main := pkg.browserMain(); main != "" {
+				return trimSourceExt(path.Join(pkg.Rel, main))
+			}
+			if pkg.Main == "" {
+				return path.Join(pkg.Rel, "index")
+			}
+			return trimSourceExt(path.Join(pkg.Rel, pkg.Main))
+		}
+		return path.Join(pkg.Rel, subpath)
+	}
+
+	exports := pkg.subpathExports()
+	if target, ok := exports[subpath]; ok {
+		return pkg.exportPath(exportTarget(target), "")
+	}
+	// Wildcard patterns like "./features/*", ordered like node's PATTERN_KEY_COMPARE
+	var matches []string
+	for pattern := range exports {
+		star := strings.Index(pattern, "*")
+		if star < 0 {
+			continue
+		}
+		prefix, suffix := pattern[:star], pattern[star+1:]
+		if len(subpath) < len(prefix)+len(suffix) || !strings.HasPrefix(subpath, prefix) || !strings.HasSuffix(subpath, suffix) {
+			continue
+		}
+		matches = append(matches, pattern)
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return patternKeyLess(matches[i], matches[j])
+	})
+	best := matches[0]
+	star := strings.Index(best, "*")
+	match := subpath[star : len(subpath)-(len(best)-star-1)]
+	return pkg.exportPath(exportTarget(exports[best]), match)
+}
+
+// patternKeyLess reports whether the wildcard pattern a takes precedence over b: the
+// longer prefix before the wildcard wins, then the longer pattern. Patterns tying on
+// both are ordered by name, so the choice doesn't depend on the order of the map.
+func patternKeyLess(a, b string) bool {
+	if starA, starB := strings.Index(a, "*"), strings.Index(b, "*"); starA != starB {
+		return starA > starB
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a < b
+}
+
+// browserMain returns the file the browser field replaces the main file with, if any.
+func (pkg *packageJSON) browserMain() string {
+	switch browser := pkg.Browser.(type) {
+	case string:
+		return browser
+	case map[string]interface{}:
+		main := pkg.Main
+		if main == "" {
+			main = "index"
+		}
+		for key, value := range browser {
+			if s, ok := value.(string); ok && strings.HasPrefix(key, ".") && trimSourceExt(path.Clean(key)) == trimSourceExt(path.Clean(main)) {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// browserReplacement looks up an import of a file in the package in its browser field.
+// Modules are matched by their name imp, files by their path p relative to the
+// repository root. The replacement is either false or a module name or file relative
+// to the package.
+func (pkg *packageJSON) browserReplacement(imp, p string) (interface{}, bool) {
+	browser, ok := pkg.Browser.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if !strings.HasPrefix(imp, ".") {
+		value, ok := browser[imp]
+		return value, ok
+	}
+	for key, value := range browser {
+		if strings.HasPrefix(key, ".") && trimSourceExt(path.Join(pkg.Rel, key)) == trimSourceExt(p) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// publishes reports whether the file p, relative to the repository root and without
+// extension, is included by the files allowlist. The main file is always published.
+func (pkg *packageJSON) publishes(p string) bool {
+	if len(pkg.Files) == 0 || p == pkg.resolveSubpath(".") {
+		return true
+	}
+	rel := strings.TrimPrefix(p, pkg.Rel+"/")
+	for _, entry := range pkg.Files {
+		entry = strings.TrimSuffix(strings.TrimSuffix(path.Clean(entry), "/**"), "/*")
+		if strings.Contains(entry, "*") {
+			if ok, _ := path.Match(trimSourceExt(entry), rel); ok {
+				return true
+			}
+			continue
+		}
+		// Directories include everything below them
+		if entry = trimSourceExt(entry); rel == entry || strings.HasPrefix(rel, entry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the directory dir is part of the package.
+func (pkg *packageJSON) contains(dir string) bool {
+	return pkg.Rel == "" || dir == pkg.Rel || strings.HasPrefix(dir, pkg.Rel+"/")
+}
+
+// subpathExports normalises the "exports" field into a map of subpath to target,
+// as a plain string or an object of conditions is shorthand for the "." subpath.
+func (pkg *packageJSON) subpathExports() map[string]interface{} {
+	if exports, ok := pkg.Exports.(map[string]interface{}); ok {
+		for key := range exports {
+			if strings.HasPrefix(key, ".") {
+				return exports
+			}
+		}
+	}
+	return map[string]interface{}{".": pkg.Exports}
+}
+
+// exportPath turns an export target into a path relative to the repository root,
+// substituting match for the wildcard.
+func (pkg *packageJSON) exportPath(target, match string) string {
+	if target == "" {
+		return ""
+	}
+	return trimSourceExt(path.Join(pkg.Rel, strings.Replace(target, "*", match, -1)))
+}
+
+// exportTarget picks the file of an export target, which may be nested in conditions.
+// Null targets, which exclude a subpath, result in an empty string.
+func exportTarget(target interface{}) string {
+	switch t := target.(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, alternative := range t {
+			if s := exportTarget(alternative); s != "" {
+				return s
+			}
+		}
+	case map[string]interface{}:
+		for _, condition := range exportConditions {
+			if s := exportTarget(t[condition]); s != "" {
+				return s
+			}
+		}
+		conditions := make([]string, 0, len(t))
+		for condition := range t {
+			conditions = append(conditions, condition)
+		}
+		sort.Strings(conditions)
+		for _, condition := range conditions {
+			if s := exportTarget(t[condition]); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// packageRegistry indexes the first-party packages of the repository by name.
+// It is filled while configuring each directory and shared by all configs.
+type packageRegistry struct {
+	byName map[string]*packageJSON
+
+	// byDir holds all packages by their directory relative to the repository root.
+	byDir map[string]*packageJSON
+
+	// outDirs maps the outDir of each tsconfig.json to its rootDir, both relative
+	// to the repository root, so imports of compiled output can be traced back to
+	// the sources.
+	outDirs map[string]string
+}
+
+func newPackageRegistry() *packageRegistry {
+	return &packageRegistry{byName: make(map[string]*packageJSON), byDir: make(map[string]*packageJSON), outDirs: make(map[string]string)}
+}
+
+func (reg *packageRegistry) addOutDir(outDir, rootDir string) {
+	reg.outDirs[outDir] = rootDir
+}
+
+// sourcePath maps a path inside the outDir of a tsconfig.json to the corresponding
+// path in its rootDir. Other paths are returned as is.
+func (reg *packageRegistry) sourcePath(p string) string {
+	if reg == nil {
+		return p
+	}
+	for dir := p; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if rootDir, ok := reg.outDirs[dir]; ok {
+			return path.Join(rootDir, strings.TrimPrefix(p, dir))
+		}
+	}
+	return p
+}
+
+func (reg *packageRegistry) add(pkg *packageJSON) {
+	if pkg.Name != "" {
+		reg.byName[pkg.Name] = pkg
+	}
+	reg.byDir[pkg.Rel] = pkg
+}
+
+// closest returns the package containing the directory dir, nil if there is none.
+func (reg *packageRegistry) closest(dir string) *packageJSON {
+	if reg == nil {
+		return nil
+	}
+	for {
+		if pkg, ok := reg.byDir[dir]; ok {
+			return pkg
+		}
+		if dir == "" {
+			return nil
+		}
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+	}
+}
+
+// lookup finds the first-party package imp refers to and returns it along with the
+// imported subpath, "." for the package itself. nil is returned for other imports.
+func (reg *packageRegistry) lookup(imp string) (*packageJSON, string) {
+	if reg == nil {
+		return nil, ""
+	}
+	name := imp
+	for {
+		if pkg, ok := reg.byName[name]; ok {
+			if name == imp {
+				return pkg, "."
+			}
+			return pkg, "./" + strings.TrimPrefix(imp, name+"/")
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return nil, ""
+		}
+		name = name[:i]
+	}
+}
@@ -0,0 +1,211 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPackageJSONResolveSubpath(t *testing.T) {
+	for _, tc := range []struct {
+		desc, packageJSON, subpath, want string
+	}{
+		{
+			desc:        "main",
+			packageJSON: `{"name": "@acme/lib", "main": "lib/main.js"}`,
+			subpath:     ".",
+			want:        "packages/lib/lib/main",
+		},
+		{
+			desc:        "no main",
+			packageJSON: `{"name": "@acme/lib"}`,
+			subpath:     ".",
+			want:        "packages/lib/index",
+		},
+		{
+			desc:        "browser string",
+			packageJSON: `{"name": "@acme/lib", "main": "lib/main.js", "browser": "lib/browser.js"}`,
+			subpath:     ".",
+			want:        "packages/lib/lib/browser",
+		},
+		{
+			desc:        "browser replaces main",
+			packageJSON: `{"name": "@acme/lib", "main": "./lib/main.js", "browser": {"./lib/main.js": "./lib/browser.js", "fs": false}}`,
+			subpath:     ".",
+			want:        "packages/lib/lib/browser",
+		},
+		{
+			desc:        "no exports",
+			packageJSON: `{"name": "@acme/lib"}`,
+			subpath:     "./utils/strings",
+			want:        "packages/lib/utils/strings",
+		},
+		{
+			desc:        "exports string",
+			packageJSON: `{"name": "@acme/lib", "exports": "./dist/index.js"}`,
+			subpath:     ".",
+			want:        "packages/lib/dist/index",
+		},
+		{
+			desc:        "exports conditions",
+			packageJSON: `{"name": "@acme/lib", "exports": {"require": "./dist/index.cjs", "import": "./dist/index.mjs"}}`,
+			subpath:     ".",
+			want:        "packages/lib/dist/index.mjs",
+		},
+		{
+			desc:        "exact subpath",
+			packageJSON: `{"name": "@acme/lib", "exports": {".": "./dist/index.js", "./utils": "./dist/utils.js"}}`,
+			subpath:     "./utils",
+			want:        "packages/lib/dist/utils",
+		},
+		{
+			desc:        "wildcard subpath",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./features/*": "./dist/features/*.js"}}`,
+			subpath:     "./features/auth",
+			want:        "packages/lib/dist/features/auth",
+		},
+		{
+			desc:        "nested wildcard subpath",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./features/*": "./dist/features/*.js"}}`,
+			subpath:     "./features/auth/login",
+			want:        "packages/lib/dist/features/auth/login",
+		},
+		{
+			desc:        "longest wildcard prefix wins",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./*": "./src/*.js", "./features/*": {"import": "./dist/features/*.js"}}}`,
+			subpath:     "./features/auth",
+			want:        "packages/lib/dist/features/auth",
+		},
+		{
+			desc:        "longest wildcard pattern wins on equal prefixes",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./features/*": "./dist/features/*.js", "./features/*.js": "./src/features/*.js"}}`,
+			subpath:     "./features/auth.js",
+			want:        "packages/lib/src/features/auth",
+		},
+		{
+			desc:        "exact subpath preferred over wildcard",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./features/*": "./dist/features/*.js", "./features/legacy": "./legacy/index.js"}}`,
+			subpath:     "./features/legacy",
+			want:        "packages/lib/legacy/index",
+		},
+		{
+			desc:        "excluded subpath",
+			packageJSON: `{"name": "@acme/lib", "exports": {"./features/*": "./dist/features/*.js", "./features/internal": null}}`,
+			subpath:     "./features/internal",
+			want:        "",
+		},
+		{
+			desc:        "not exported",
+			packageJSON: `{"name": "@acme/lib", "exports": {".": "./dist/index.js"}}`,
+			subpath:     "./dist/secret",
+			want:        "",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			pkg := &packageJSON{Rel: "packages/lib"}
+			if err := json.Unmarshal([]byte(tc.packageJSON), pkg); err != nil {
+				t.Fatal(err)
+			}
+
+			got := pkg.resolveSubpath(tc.subpath)
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPackageRegistryLookup(t *testing.T) {
+	reg := newPackageRegistry()
+	reg.add(&packageJSON{Name: "@acme/lib", Rel: "packages/lib"})
+	reg.add(&packageJSON{Name: "utils", Rel: "packages/utils"})
+	for _, tc := range []struct {
+		imp, wantRel, wantSubpath string
+	}{
+		{imp: "@acme/lib", wantRel: "packages/lib", wantSubpath: "."},
+		{imp: "@acme/lib/features/auth", wantRel: "packages/lib", wantSubpath: "./features/auth"},
+		{imp: "utils/strings", wantRel: "packages/utils", wantSubpath: "./strings"},
+		{imp: "@acme/library", wantRel: "", wantSubpath: ""},
+		{imp: "lodash", wantRel: "", wantSubpath: ""},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			pkg, subpath := reg.lookup(tc.imp)
+			var rel string
+			if pkg != nil {
+				rel = pkg.Rel
+			}
+			if rel != tc.wantRel || subpath != tc.wantSubpath {
+				t.Errorf("Inequalith.\ngot  %#v, %#v;\nwant %#v, %#v", rel, subpath, tc.wantRel, tc.wantSubpath)
+			}
+		})
+	}
+}
+
+func TestPackageRegistrySourcePath(t *testing.T) {
+	reg := newPackageRegistry()
+	reg.addOutDir("packages/b/dist", "packages/b/src")
+	reg.addOutDir("packages/c/lib", "packages/c")
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{path: "packages/b/dist/utils", want: "packages/b/src/utils"},
+		{path: "packages/b/dist", want: "packages/b/src"},
+		{path: "packages/b/distribution/utils", want: "packages/b/distribution/utils"},
+		{path: "packages/c/lib/nested/file", want: "packages/c/nested/file"},
+		{path: "packages/d/dist/utils", want: "packages/d/dist/utils"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			got := reg.sourcePath(tc.path)
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPackageJSONPublishes(t *testing.T) {
+	pkg := &packageJSON{
+		Rel:   "packages/lib",
+		Main:  "main.js",
+		Files: []string{"dist/", "types/*.d.ts", "cli.js", "assets/**"},
+	}
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{path: "packages/lib/main", want: true},
+		{path: "packages/lib/dist", want: true},
+		{path: "packages/lib/dist/utils/strings", want: true},
+		{path: "packages/lib/types/index.d", want: true},
+		{path: "packages/lib/cli", want: true},
+		{path: "packages/lib/assets/logo.svg", want: true},
+		{path: "packages/lib/src/internal", want: false},
+		{path: "packages/lib/distribution/index", want: false},
+		{path: "packages/lib/types/nested/index.d", want: false},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := pkg.publishes(tc.path); got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+	if !(&packageJSON{Rel: "packages/lib"}).publishes("packages/lib/src/internal") {
+		t.Errorf("expected all files to be published without a files allowlist")
+	}
+}
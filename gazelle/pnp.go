@@ -0,0 +1,166 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pnpDataFile is the dependency graph yarn writes for Plug'n'Play installs when
+// pnpEnableInlining is disabled.
+const pnpDataFile = ".pnp.data.json"
+
+// pnpData holds the dependencies of each workspace of a Plug'n'Play install.
+type pnpData struct {
+	workspaces []pnpWorkspace
+}
+
+// pnpWorkspace is a workspace of the repository and the packages it may import.
+type pnpWorkspace struct {
+	// Dir is the directory of the workspace relative to the repository root.
+	Dir  string
+	Deps map[string]pnpDependency
+}
+
+// pnpDependency is the package an import name refers to, after resolving aliases
+// like "my-lodash": "npm:lodash@4" and virtual packages.
+type pnpDependency struct {
+	// Name is the real name of the package, which is the name of its npm target.
+	Name string
+	// Workspace is the directory of the package if it is a workspace of the repository.
+	Workspace string
+}
+
+// pnpPackage is an entry of the packageRegistryData of .pnp.data.json.
+type pnpPackage struct {
+	PackageLocation     string               `json:"packageLocation"`
+	PackageDependencies [][2]json.RawMessage `json:"packageDependencies"`
+}
+
+// loadPnpData reads the .pnp.data.json in the repository root.
+func loadPnpData(root string) (*pnpData, error) {
+	content, err := ioutil.ReadFile(filepath.Join(root, pnpDataFile))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		PackageRegistryData [][2]json.RawMessage `json:"packageRegistryData"`
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", pnpDataFile, err)
+	}
+
+	pnp := &pnpData{}
+	for _, entry := range data.PackageRegistryData {
+		var references [][2]json.RawMessage
+		if err := json.Unmarshal(entry[1], &references); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", pnpDataFile, err)
+		}
+		for _, ref := range references {
+			var reference *string
+			var pkg pnpPackage
+			if err := json.Unmarshal(ref[0], &reference); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", pnpDataFile, err)
+			}
+			if err := json.Unmarshal(ref[1], &pkg); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", pnpDataFile, err)
+			}
+			// The top-level package has no reference, the others are workspace:<dir>
+			if reference != nil && !strings.HasPrefix(*reference, "workspace:") {
+				continue
+			}
+			ws := pnpWorkspace{Dir: path.Clean(pkg.PackageLocation), Deps: make(map[string]pnpDependency)}
+			if ws.Dir == "." {
+				ws.Dir = ""
+			}
+			for _, dep := range pkg.PackageDependencies {
+				var name string
+				if err := json.Unmarshal(dep[0], &name); err != nil {
+					return nil, fmt.Errorf("error parsing %s: %v", pnpDataFile, err)
+				}
+				if d, ok := parsePnpDependency(name, dep[1]); ok {
+					ws.Deps[name] = d
+				}
+			}
+			pnp.workspaces = append(pnp.workspaces, ws)
+		}
+	}
+	return pnp, nil
+}
+
+// parsePnpDependency interprets the reference of a dependency, which is either a
+// string like "npm:1.0.0" or, for aliases, the real name and its reference. Missing
+// peer dependencies have no reference and are skipped.
+func parsePnpDependency(name string, raw json.RawMessage) (pnpDependency, bool) {
+	var reference string
+	if err := json.Unmarshal(raw, &reference); err != nil {
+		var alias [2]string
+		if err := json.Unmarshal(raw, &alias); err != nil {
+			return pnpDependency{}, false
+		}
+		name, reference = alias[0], alias[1]
+	}
+	if reference == "" {
+		return pnpDependency{}, false
+	}
+	// Virtual packages like virtual:<hash>#npm:1.0.0 are instances of the package after the #
+	if strings.HasPrefix(reference, "virtual:") {
+		if i := strings.Index(reference, "#"); i >= 0 {
+			reference = reference[i+1:]
+		}
+	}
+	dep := pnpDependency{Name: name}
+	if strings.HasPrefix(reference, "workspace:") {
+		dep.Workspace = path.Clean(strings.TrimPrefix(reference, "workspace:"))
+		if dep.Workspace == "." {
+			dep.Workspace = ""
+		}
+	}
+	return dep, true
+}
+
+// lookup finds the package imp refers to when imported from the package pkg, using the
+// dependencies of the closest workspace. It also returns the imported subpath, "" for
+// the package itself.
+func (pnp *pnpData) lookup(imp, pkg string) (pnpDependency, string, bool) {
+	if pnp == nil {
+		return pnpDependency{}, "", false
+	}
+	parts := strings.SplitN(imp, "/", 3)
+	name, subpath := parts[0], strings.Join(parts[1:], "/")
+	if strings.HasPrefix(imp, "@") && len(parts) > 1 {
+		name, subpath = parts[0]+"/"+parts[1], strings.Join(parts[2:], "/")
+	}
+	var ws *pnpWorkspace
+	for i, w := range pnp.workspaces {
+		if w.Dir != "" && pkg != w.Dir && !strings.HasPrefix(pkg, w.Dir+"/") {
+			continue
+		}
+		if ws == nil || len(w.Dir) > len(ws.Dir) {
+			ws = &pnp.workspaces[i]
+		}
+	}
+	if ws == nil {
+		return pnpDependency{}, "", false
+	}
+	dep, ok := ws.Deps[name]
+	return dep, subpath, ok
+}
@@ -0,0 +1,145 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const pnpDataFixture = `{
+  "__info": ["This file is automatically generated. Do not touch it."],
+  "dependencyTreeRoots": [
+    {"name": "monorepo", "reference": "workspace:."},
+    {"name": "@acme/app", "reference": "workspace:packages/app"},
+    {"name": "@acme/ui", "reference": "workspace:packages/ui"}
+  ],
+  "packageRegistryData": [
+    [null, [
+      [null, {
+        "packageLocation": "./",
+        "packageDependencies": [["typescript", "patch:typescript@npm%3A4.9.5#~builtin<compat/typescript>::version=4.9.5"]],
+        "linkType": "SOFT"
+      }]
+    ]],
+    ["@acme/app", [
+      ["workspace:packages/app", {
+        "packageLocation": "./packages/app/",
+        "packageDependencies": [
+          ["@acme/ui", "workspace:packages/ui"],
+          ["my-lodash", ["lodash", "npm:4.17.21"]],
+          ["react-dom", "virtual:8c2a5e#npm:18.2.0"],
+          ["react", null]
+        ],
+        "linkType": "SOFT"
+      }]
+    ]],
+    ["@acme/ui", [
+      ["workspace:packages/ui", {
+        "packageLocation": "./packages/ui/",
+        "packageDependencies": [["lodash", "npm:4.17.21"]],
+        "linkType": "SOFT"
+      }]
+    ]],
+    ["lodash", [
+      ["npm:4.17.21", {
+        "packageLocation": "./.yarn/cache/lodash-npm-4.17.21-6382451519-eb835a2e51.zip/node_modules/lodash/",
+        "packageDependencies": [["lodash", "npm:4.17.21"]],
+        "linkType": "HARD"
+      }]
+    ]]
+  ]
+}`
+
+func TestPnpDataLookup(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestPnpDataLookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, pnpDataFile), []byte(pnpDataFixture), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pnp, err := loadPnpData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc, imp, pkg string
+		want           pnpDependency
+		wantSubpath    string
+		wantOk         bool
+	}{
+		{
+			desc:        "workspace",
+			imp:         "@acme/ui/Button",
+			pkg:         "packages/app/src",
+			want:        pnpDependency{Name: "@acme/ui", Workspace: "packages/ui"},
+			wantSubpath: "Button",
+			wantOk:      true,
+		},
+		{
+			desc:        "alias",
+			imp:         "my-lodash/fp",
+			pkg:         "packages/app",
+			want:        pnpDependency{Name: "lodash"},
+			wantSubpath: "fp",
+			wantOk:      true,
+		},
+		{
+			desc:   "virtual package",
+			imp:    "react-dom",
+			pkg:    "packages/app",
+			want:   pnpDependency{Name: "react-dom"},
+			wantOk: true,
+		},
+		{
+			desc: "missing peer dependency",
+			imp:  "react",
+			pkg:  "packages/app",
+		},
+		{
+			desc:   "dependencies of the closest workspace",
+			imp:    "lodash",
+			pkg:    "packages/ui",
+			want:   pnpDependency{Name: "lodash"},
+			wantOk: true,
+		},
+		{
+			desc: "not a dependency of the workspace",
+			imp:  "my-lodash",
+			pkg:  "packages/ui",
+		},
+		{
+			desc:   "top-level workspace",
+			imp:    "typescript",
+			pkg:    "tools",
+			want:   pnpDependency{Name: "typescript"},
+			wantOk: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, subpath, ok := pnp.lookup(tc.imp, tc.pkg)
+
+			if got != tc.want || subpath != tc.wantSubpath || ok != tc.wantOk {
+				t.Errorf("Inequalith.\ngot  %#v, %#v, %v;\nwant %#v, %#v, %v", got, subpath, ok, tc.want, tc.wantSubpath, tc.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// npmDepsReport collects the npm packages the generated rules of each Bazel package
+// depend on. It is shared by all configs and written to path as JSON like
+// {"//app": ["lodash", "react"]}.
+//
+// A run replaces the entries of the packages it generates rules for and keeps the others,
+// so runs in a subdirectory don't drop the rest of the repository.
+type npmDepsReport struct {
+	path     string
+	packages map[string]map[string]bool
+}
+
+// newNpmDepsReport returns the report at path, starting from its current content if the
+// file exists.
+func newNpmDepsReport(path string) (*npmDepsReport, error) {
+	report := &npmDepsReport{path: path, packages: make(map[string]map[string]bool)}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return report, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var existing map[string][]string
+	if err := json.Unmarshal(content, &existing); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for pkg, names := range existing {
+		report.packages[pkg] = make(map[string]bool)
+		for _, name := range names {
+			report.packages[pkg][name] = true
+		}
+	}
+	return report, nil
+}
+
+// reportKey returns the key of the package pkg of the repository repo in the report,
+// like //app, or @other//app outside the main repository.
+func reportKey(repo, pkg string) string {
+	if repo != "" {
+		return "@" + repo + "//" + pkg
+	}
+	return "//" + pkg
+}
+
+// reset drops the entry of a package whose rules are generated again.
+func (report *npmDepsReport) reset(repo, pkg string) {
+	if report == nil {
+		return
+	}
+	delete(report.packages, reportKey(repo, pkg))
+}
+
+// add records the npm dependencies among deps of a rule in the package of from, where
+// npm dependencies are labels in the npm workspace like @npm//lodash.
+func (report *npmDepsReport) add(from label.Label, deps []string, npmWorkspaceName string) {
+	if report == nil {
+		return
+	}
+	pkg := reportKey(from.Repo, from.Pkg)
+	for _, dep := range deps {
+		if !strings.HasPrefix(dep, "@"+npmWorkspaceName+"//") {
+			continue
+		}
+		name := strings.TrimPrefix(dep, "@"+npmWorkspaceName+"//")
+		if report.packages[pkg] == nil {
+			report.packages[pkg] = make(map[string]bool)
+		}
+		report.packages[pkg][name] = true
+	}
+}
+
+// write writes the report to its path. Gazelle does not notify languages when a run is
+// done, so this is called once the last generated rule is resolved.
+func (report *npmDepsReport) write() error {
+	if report == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(report.packages))
+	for pkg, names := range report.packages {
+		for name := range names {
+			out[pkg] = append(out[pkg], name)
+		}
+		sort.Strings(out[pkg])
+	}
+	content, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(report.path, append(content, '\n'), 0644)
+}
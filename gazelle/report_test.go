@@ -0,0 +1,97 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestNpmDepsReport(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestNpmDepsReport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "npm_deps.json")
+	// A previous run covered other packages, and lib before it lost its npm dependencies
+	previous := `{
+  "//docs": [
+    "mdx"
+  ],
+  "//lib": [
+    "lodash"
+  ]
+}
+`
+	if err := ioutil.WriteFile(path, []byte(previous), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := newNpmDepsReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range []string{"app", "lib", "lib/ui"} {
+		report.reset("", pkg)
+	}
+	report.reset("other", "vendor")
+	adds := []struct {
+		from label.Label
+		deps []string
+	}{
+		{label.New("", "app", "app"), []string{"//lib", "@npm//react", "@npm//lodash"}},
+		{label.New("", "app", "app_test"), []string{"//app", "@npm//jest", "@npm//react"}},
+		{label.New("", "lib", "lib"), []string{"@other//dep"}},
+		{label.New("", "lib/ui", "ui"), []string{"@npm//@types/react"}},
+		{label.New("other", "vendor", "vendor"), []string{"@npm//left-pad"}},
+	}
+	for _, a := range adds {
+		report.add(a.from, a.deps, "npm")
+	}
+	if err := report.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "//app": [
+    "jest",
+    "lodash",
+    "react"
+  ],
+  "//docs": [
+    "mdx"
+  ],
+  "//lib/ui": [
+    "@types/react"
+  ],
+  "@other//vendor": [
+    "left-pad"
+  ]
+}
+`
+	if got := string(content); got != want {
+		t.Errorf("Inequalith.\ngot  %s;\nwant %s", got, want)
+	}
+}
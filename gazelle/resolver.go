@@ -21,6 +21,7 @@ import (
 	"log"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"github.com/bazelbuild/bazel-gazelle/config"
@@ -28,6 +29,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 var _ = fmt.Printf
@@ -81,6 +83,18 @@ var (
 	}
 )
 
+// ImportResolver is an extension point for imports the built-in resolution can't
+// handle. To use one, build a gazelle_binary from a go_library whose NewLanguage
+// calls this package's NewLanguage with the resolver.
+//
+// Imports are resolved by, in order, # gazelle:resolve directives, the custom
+// resolvers, the rule index and finally the npm heuristics.
+type ImportResolver interface {
+	// ResolveImport returns the label of the rule providing imp, as written in the source
+	// of the rule from. If ok is false the import is left to the next resolver.
+	ResolveImport(c *config.Config, imp string, from label.Label) (l label.Label, ok bool)
+}
+
 // Name returns the name of the language. This should be a prefix of the
 // kinds of rules generated by the language, e.g., "go" for the Go extension
 // since it generates "go_library" rules.
@@ -94,25 +108,203 @@ func (s *jslang) Name() string {
 // If nil is returned, the rule will not be indexed. If any non-nil slice is
 // returned, including an empty slice, the rule will be indexed.
 func (s *jslang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	imports := ruleImports(c, r, f)
+	js := GetJsConfig(c)
+	if imports == nil || ruleKind(c, r) == r.Kind() || js.mappedImports == nil {
+		return imports
+	}
+	// Rules of mapped kinds are indexed by the plugin, see mappedImports
+	l := label.New(c.RepoName, f.Pkg, r.Name())
+	for _, imp := range imports {
+		js.mappedImports[imp] = append(js.mappedImports[imp], resolve.FindResult{Label: l})
+	}
+	return []resolve.ImportSpec{}
+}
+
+// ruleImports returns the ImportSpecs r can be imported with.
+func ruleImports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
 	rel := f.Pkg
-	var withoutSuffix string
-	srcs := r.AttrStrings("srcs")
 	js := GetJsConfig(c)
-	imports := make([]resolve.ImportSpec, len(srcs))
-	for i, src := range srcs {
+	if r.Kind() == "filegroup" {
+		// Only the package.json filegroups are imported. The files of the shared ones are
+		// indexed through the rules that use them, and other filegroups aren't ours.
+		if isPackageJSONTarget(r) {
+			return []resolve.ImportSpec{{Lang: "js", Imp: path.Join(rel, "package.json")}}
+		}
+		return nil
+	}
+	if r.Kind() == "alias" {
+		return aliasImports(r, label.New(c.RepoName, rel, r.Name()), js)
+	}
+	if r.Kind() == "ts_proto_library" {
+		return protoImports(r, f)
+	}
+	var withoutSuffix string
+	srcs := ruleSrcs(r, f)
+	imports := make([]resolve.ImportSpec, 0, len(srcs))
+	seen := make(map[string]bool)
+	for _, src := range srcs {
+		if migratedSibling(src, f, js) {
+			// The import resolves to the rule of the sibling instead
+			continue
+		}
 		if containsSuffix(js.JsImportExtenstions, src) {
 			withoutSuffix = src
 		} else {
 			withoutSuffix = strings.TrimSuffix(src, path.Ext(src))
 		}
-		imports[i] = resolve.ImportSpec{
-			Lang: "js",
-			Imp:  (path.Join(rel, withoutSuffix)),
+		// Platform variants like Button.ios.js are also imported as ./Button
+		for _, imp := range []string{withoutSuffix, platformBase(withoutSuffix, js.PlatformExtensions)} {
+			if !seen[imp] {
+				seen[imp] = true
+				imports = append(imports, resolve.ImportSpec{
+					Lang: "js",
+					Imp:  (path.Join(rel, imp)),
+				})
+			}
+		}
+		if strings.HasSuffix(src, ".d.ts") {
+			imports = append(imports, umdImports(r, filepath.Join(c.RepoRoot, rel), rel, src)...)
 		}
 	}
+	if js.TypesTarget != "" && ruleKind(c, r) == "ts_project" && hasRuleNamed(f, js.typesTarget(r.Name())) {
+		imports = append(imports, resolve.ImportSpec{Lang: "js", Imp: typesTargetPrefix + path.Join(rel, r.Name())})
+	}
 	return imports
 }
 
+// typesTargetPrefix prefixes the ts_projects in the index whose declaration sub-target
+// of js_types_target exists, which can't clash with paths.
+const typesTargetPrefix = "types:"
+
+// hasRuleNamed reports whether f has a rule called name.
+func hasRuleNamed(f *rule.File, name string) bool {
+	for _, r := range f.Rules {
+		if r.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// declarationTarget returns the declaration sub-target of the ts_project l, which
+// type-only imports of it resolve to, or l itself if there is none.
+func declarationTarget(ix *resolve.RuleIndex, l label.Label, js *JsConfig) label.Label {
+	if js.TypesTarget == "" {
+		return l
+	}
+	if len(findRulesByImport(ix, resolve.ImportSpec{Lang: "js", Imp: typesTargetPrefix + path.Join(l.Pkg, l.Name)}, js)) == 0 {
+		return l
+	}
+	return label.New(l.Repo, l.Pkg, js.typesTarget(l.Name))
+}
+
+// umdGlobalsKey is the private attribute holding the UMD globals of the declarations in
+// the package of a generated rule, by declaration.
+const umdGlobalsKey = "_js_umd_globals"
+
+// umdImports indexes a UMD declaration file, i.e. one declaring a global with
+// export as namespace, by its global for the files using it without importing it,
+// and like a module without the .d suffix for the ones importing it.
+func umdImports(r *rule.Rule, dir, rel, src string) []resolve.ImportSpec {
+	var globals []string
+	if umdGlobals, ok := r.PrivateAttr(umdGlobalsKey).(map[string][]string); ok {
+		globals = umdGlobals[src]
+	} else {
+		// The rules of packages gazelle doesn't update in this run are only indexed
+		globals = jsFileinfo(dir, src).Globals
+	}
+	if len(globals) == 0 {
+		return nil
+	}
+	imports := []resolve.ImportSpec{{Lang: "js", Imp: path.Join(rel, strings.TrimSuffix(src, ".d.ts"))}}
+	for _, global := range globals {
+		imports = append(imports, resolve.ImportSpec{Lang: "js", Imp: umdGlobalPrefix + global})
+	}
+	return imports
+}
+
+// umdGlobalPrefix prefixes the UMD globals in the index, which can't clash with paths.
+const umdGlobalPrefix = "global:"
+
+// aliasImports indexes the alias rule l by its name when js_index_aliases is enabled and
+// records its actual target, which imports of it resolve to. Aliases whose actual target
+// is chosen with select() are skipped.
+func aliasImports(r *rule.Rule, l label.Label, js *JsConfig) []resolve.ImportSpec {
+	if !js.IndexAliases || r.AttrString("actual") == "" {
+		return nil
+	}
+	actual, err := label.Parse(r.AttrString("actual"))
+	if err != nil {
+		log.Printf("%s: invalid actual of alias: %v", l, err)
+		return nil
+	}
+	if js.aliases != nil {
+		js.aliases[l] = actual.Abs(l.Repo, l.Pkg)
+	}
+	return []resolve.ImportSpec{{Lang: "js", Imp: path.Join(l.Pkg, l.Name)}}
+}
+
+// protoImports indexes a ts_proto_library by the modules protoc-gen-es and
+// protoc-gen-connect-es generate in its package for each proto, e.g. eliza_pb and
+// eliza_connect for eliza.proto. The protos are the srcs of its proto_library if that is
+// in the same package, and are otherwise named after it without the _proto suffix.
+func protoImports(r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	protoLabel, err := label.Parse(r.AttrString("proto"))
+	if err != nil {
+		return nil
+	}
+	var protos []string
+	if protoLabel.Repo == "" && (protoLabel.Relative || protoLabel.Pkg == f.Pkg) {
+		for _, pr := range f.Rules {
+			if pr.Kind() == "proto_library" && pr.Name() == protoLabel.Name {
+				protos = pr.AttrStrings("srcs")
+			}
+		}
+	}
+	if len(protos) == 0 {
+		protos = []string{strings.TrimSuffix(protoLabel.Name, "_proto")}
+	}
+	var imports []resolve.ImportSpec
+	for _, proto := range protos {
+		base := path.Join(f.Pkg, strings.TrimSuffix(path.Base(proto), ".proto"))
+		for _, suffix := range []string{"_pb", "_connect"} {
+			imports = append(imports, resolve.ImportSpec{Lang: "js", Imp: base + suffix})
+		}
+	}
+	return imports
+}
+
+// migrationExtensions are the extensions of each language js_migration_prefer chooses between.
+var migrationExtensions = map[string][]string{
+	"js": {".js", ".jsx"},
+	"ts": {".ts", ".tsx"},
+}
+
+// migratedSibling reports whether src has a sibling in the preferred language of
+// js_migration_prefer among the sources of f, e.g. foo.ts for foo.js.
+func migratedSibling(src string, f *rule.File, js *JsConfig) bool {
+	if js.MigrationPrefer == "" || containsSuffix(migrationExtensions[js.MigrationPrefer], src) || strings.HasSuffix(src, ".d.ts") {
+		return false
+	}
+	other := "ts"
+	if js.MigrationPrefer == "ts" {
+		other = "js"
+	}
+	if !containsSuffix(migrationExtensions[other], src) {
+		return false
+	}
+	base := strings.TrimSuffix(src, path.Ext(src))
+	for _, r := range f.Rules {
+		for _, sibling := range ruleSrcs(r, f) {
+			if strings.TrimSuffix(sibling, path.Ext(sibling)) == base && containsSuffix(migrationExtensions[js.MigrationPrefer], sibling) && !strings.HasSuffix(sibling, ".d.ts") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Embeds returns a list of labels of rules that the given rule embeds. If
 // a rule is embedded by another importable rule of the same language, only
 // the embedding rule will be indexed. The embedding rule will inherit
@@ -129,15 +321,82 @@ func (s *jslang) Embeds(r *rule.Rule, from label.Label) []label.Label {
 // attribute (or the appropriate language-specific equivalent) for each
 // import according to language-specific rules and heuristics.
 func (s *jslang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, importsRaw interface{}, from label.Label) {
-	imports := importsRaw.([]string)
+	if r.Kind() == "filegroup" {
+		return
+	}
+	info := importsRaw.(FileInfo)
 	js := GetJsConfig(c)
-	r.DelAttr("deps")
+	defer ruleResolved(js)
+	kind := ruleKind(c, r)
+	r.DelAttr(js.depsAttr())
 	r.DelAttr("data")
+	r.DelAttr("js_imports")
 	depSet := make(map[string]bool)
 	dataSet := make(map[string]bool)
+	// resolved records the label each import was resolved to for js_persist_imports
+	resolved := make(map[string]string)
+	var raw string
+	addDep := func(dep string) {
+		depSet[dep] = true
+		resolved[raw] = dep
+	}
+	imports := info.Imports
+	if includeTypeOnlyDeps(kind, js) {
+		imports = append(append([]string{}, imports...), info.TypeImports...)
+	}
+	declarationOnly := make(map[string]bool)
+	for _, imp := range info.DeclarationImports {
+		declarationOnly[imp] = true
+	}
 	for _, imp := range imports {
-		normalisedImp := normaliseImports(imp, ix, from, js.AliasImportSupport)
-		l, err := resolveWithIndex(ix, normalisedImp, from)
+		raw = imp
+		resolved[raw] = ""
+		imp = stripVueQuery(imp)
+		if loaders, resource := splitInlineLoaders(imp); resource != imp {
+			// Webpack requests the resource with its extension, the index has it without
+			imp = trimSourceExt(resource)
+			if js.InlineLoaderDeps {
+				for _, loader := range loaders {
+					depSet["@"+js.NpmWorkspaceName+"//"+loader] = true
+				}
+			}
+		}
+		if l, ok := s.resolveOverride(c, imp, from); ok {
+			if !l.Equal(from) {
+				addDep(l.Rel(from.Repo, from.Pkg).String())
+			}
+			continue
+		}
+		if hasURLScheme(imp) {
+			continue
+		}
+		if js.EnforceFiles && !publishedImport(imp, from, js) {
+			continue
+		}
+		normalisedImp := normaliseImports(imp, ix, from, js)
+		var ok bool
+		if imp, normalisedImp, ok = browserRemap(imp, normalisedImp, ix, from, js); !ok {
+			continue
+		}
+		if lang, ok := js.crossLangImport(normalisedImp); ok {
+			if l, err := resolveCrossLang(ix, lang, normalisedImp, from); err == nil {
+				addDep(l.Rel(from.Repo, from.Pkg).String())
+			} else if err == notFoundError {
+				log.Printf("Import %v for %s not found in %s rules.\n", imp, from.Abs(from.Repo, from.Pkg).String(), lang)
+				if err := js.unresolvedImport(raw, from); err != nil {
+					js.addUnresolved(err)
+				}
+			} else if err != skipImportError {
+				log.Print(err)
+			}
+			continue
+		}
+		l, err := resolveWithIndex(ix, normalisedImp, from, js)
+		if err == notFoundError && trimSourceExt(normalisedImp) != normalisedImp {
+			// Sources are indexed without their extension, which ESM specifiers like
+			// import.meta.resolve('./worker.js') and components and documents include
+			l, err = resolveWithIndex(ix, trimSourceExt(normalisedImp), from, js)
+		}
 		if err == skipImportError {
 			continue
 		} else if err == notFoundError {
@@ -147,53 +406,119 @@ func (s *jslang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remot
 			sort.Strings(builtinModules)
 			i := sort.SearchStrings(builtinModules, imp)
 			isBuiltinModule := i < len(builtinModules) && builtinModules[i] == imp
-			if isNpmDependency(imp) && !isBuiltinModule {
+			if isNpmDependency(imp) && !isBuiltinModule && !isFirstParty(imp, from, js) {
 				s := strings.Split(imp, "/")
 				imp = s[0]
 				if strings.HasPrefix(imp, "@") {
 					imp += "/" + s[1]
 				}
-				depSet["@"+js.NpmWorkspaceName+"//"+imp] = true
+				// Aliased packages are installed under their real name
+				if dep, _, ok := js.pnp.lookup(imp, from.Pkg); ok {
+					imp = dep.Name
+				}
+				addDep("@" + js.NpmWorkspaceName + "//" + imp)
 			} else if filepath.Ext(normalisedImp) == ".svg" || filepath.Ext(normalisedImp) == ".css" || filepath.Ext(normalisedImp) == ".css" {
 				// In our vue components we also allow the import of svg files so we should handle them
 				l = label.New("", path.Dir(normalisedImp), strings.TrimSuffix(path.Base(normalisedImp), filepath.Ext(normalisedImp)) + trimExt(normalisedImp))
-				depSet[l.String()] = true
+				addDep(l.String())
+			} else if js.NextJs && containsSuffix(assetExtensions, normalisedImp) {
+				// Static imports of images for next/image are processed by the Next.js build
+				l = label.New("", path.Dir(normalisedImp), path.Base(normalisedImp)).Rel(from.Repo, from.Pkg)
+				dataSet[l.String()] = true
+				resolved[raw] = l.String()
 			} else if !isBuiltinModule {
 				// Now we need to check if the import is a directory "shortcut" import, i.e. path/to/dir -> path/to/dir/index.js/.vue
-				found := false
-				for _, indexFile := range indexFiles {
-					indexImport := path.Join(normalisedImp, indexFile)
-					//l, err := resolveWithIndex(ix, normalisedImp, from)
-					l, err := resolveWithIndex(ix, indexImport, from)
-					if err == nil {
-						found = true
-						l = l.Rel(from.Repo, from.Pkg)
-						depSet[l.String()] = true
-						break
+				// An index barrel importing its own directory is skipped
+				l, err := resolveDirectoryIndex(ix, normalisedImp, from, js)
+				if err == nil {
+					if declarationOnly[raw] {
+						l = declarationTarget(ix, l, js)
 					}
-				}
-				if !found {
+					addDep(l.Rel(from.Repo, from.Pkg).String())
+				} else if err == notFoundError {
 					log.Printf("Import %v for %s not found.\n", imp, from.Abs(from.Repo, from.Pkg).String())
+					if err := js.unresolvedImport(raw, from); err != nil {
+						js.addUnresolved(err)
+					}
+				} else if err != skipImportError {
+					log.Print(err)
 				}
 			}
 		} else if err != nil {
 			log.Print(err)
 		} else {
+			if l = js.aliasActual(l); l.Equal(from) {
+				continue
+			}
+			if declarationOnly[raw] {
+				l = declarationTarget(ix, l, js)
+			}
 			l = l.Rel(from.Repo, from.Pkg)
-			if containsSuffix(js.JsImportExtenstions, normalisedImp) {
+			if containsSuffix(js.JsImportExtenstions, normalisedImp) && !cssModule(raw, info) {
 			dataSet[l.String()] = true
+			resolved[raw] = l.String()
 			} else {
-			depSet[l.String()] = true
+			addDep(l.String())
 			}
 		}
 	}
+	// UMD globals are ambient, so the declaration is needed without any import of it
+	for _, ref := range info.GlobalRefs {
+		if l, err := resolveWithIndex(ix, umdGlobalPrefix+ref, from, js); err == nil {
+			depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		} else if err != notFoundError && err != skipImportError {
+			log.Print(err)
+		}
+	}
+	for _, datum := range info.Data {
+		if strings.HasPrefix(datum, ":") {
+			// Already a label of a file in this package
+			dataSet[datum] = true
+			continue
+		}
+		l, err := resolveData(ix, normaliseImports(datum, ix, from, js), from, js)
+		if err == nil {
+			dataSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		} else if err != skipImportError {
+			log.Print(err)
+		}
+	}
+	for _, component := range info.StoryComponents {
+		// The rule of the component itself, next to the one of the barrel file it is imported from
+		if l, err := resolveModule(ix, normaliseImports(component, ix, from, js), from, js); err == nil {
+			depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		}
+	}
+	if kind == "jest_test" {
+		for _, mock := range info.Mocks {
+			if l, err := findManualMock(mock, ix, from, js); err == nil {
+				depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+			}
+		}
+	}
+	if js.TestAutoLibDep && kind == "jest_test" {
+		// foo.test.js and foo.spec.ts are named foo.test and foo.spec, the library is foo
+		libName := strings.TrimSuffix(strings.TrimSuffix(r.Name(), ".test"), ".spec")
+		if l, err := resolveWithIndex(ix, path.Join(from.Pkg, libName), from, js); err == nil {
+			depSet[l.Rel(from.Repo, from.Pkg).String()] = true
+		}
+	}
+	if kind == "jest_test" {
+		for _, dep := range js.JestRuntimeDeps {
+			depSet[dep] = true
+		}
+	}
+	if js.BabelRuntime != "" && isLibraryKind(kind, js) {
+		depSet[js.BabelRuntime] = true
+	}
 	if len(depSet) > 0 {
 		deps := make([]string, 0, len(depSet))
 		for dep := range depSet {
 			deps = append(deps, dep)
 		}
 		sort.Strings(deps)
-		r.SetAttr("deps", deps)
+		r.SetAttr(js.depsAttr(), deps)
+		js.npmDeps.add(from, deps, js.NpmWorkspaceName)
 	}
 	if len(dataSet) > 0 {
 		data := make([]string, 0, len(dataSet))
@@ -202,9 +527,15 @@ func (s *jslang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remot
 		}
 		sort.Strings(data)
 		r.SetAttr("data", data)
+	} else if data := r.PrivateAttr(existingDataKey); data != nil {
+		// Without assets of its own the data of the rule is maintained by hand
+		r.SetAttr("data", data)
+	}
+	if js.PersistImports && len(resolved) > 0 {
+		r.SetAttr("js_imports", importsDict(resolved))
 	}
-	if r.Kind() == "jest_node_test" {
-		l, err := findJsConfig("jest", ix, from)
+	if kind == "jest_node_test" {
+		l, err := findJsConfig("jest", ix, from, js)
 		if err != nil {
 			log.Printf("Jest config for %v %v", from.Abs(from.Repo, from.Pkg).String(), err)
 		} else {
@@ -212,14 +543,193 @@ func (s *jslang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Remot
 			r.SetAttr("config", l.String())
 		}
 	}
+	if kind == "cypress_test" {
+		l, err := findJsConfig("cypress", ix, from, js)
+		if err != nil {
+			log.Printf("Cypress config for %v %v", from.Abs(from.Repo, from.Pkg).String(), err)
+		} else {
+			r.SetAttr("config", l.Rel(from.Repo, from.Pkg).String())
+		}
+	}
+}
+
+// stripVueQuery removes the query of Vue virtual modules, e.g. ./Foo.vue?vue&type=script&lang.ts
+// generated by Vite for the blocks of a single file component, which refer to the component itself.
+func stripVueQuery(imp string) string {
+	i := strings.Index(imp, "?")
+	if i < 0 || !strings.HasSuffix(imp[:i], ".vue") {
+		return imp
+	}
+	if query := imp[i+1:]; query == "vue" || strings.HasPrefix(query, "vue&") {
+		return imp[:i]
+	}
+	return imp
+}
+
+// splitInlineLoaders splits webpack inline loader requests like
+// style-loader!css-loader?modules!./styles.css into the packages of the loaders and
+// the resource they load. The !, !! and -! prefixes disabling configured loaders and
+// the options of the loaders are dropped.
+func splitInlineLoaders(imp string) ([]string, string) {
+	i := strings.LastIndex(imp, "!")
+	if i < 0 {
+		return nil, imp
+	}
+	var loaders []string
+	for _, loader := range strings.Split(strings.TrimLeft(imp[:i], "-!"), "!") {
+		if j := strings.Index(loader, "?"); j >= 0 {
+			loader = loader[:j]
+		}
+		if loader == "" {
+			continue
+		}
+		// The npm target of a loader like @acme/loaders/svg is its package
+		parts := strings.Split(loader, "/")
+		loader = parts[0]
+		if strings.HasPrefix(loader, "@") && len(parts) > 1 {
+			loader += "/" + parts[1]
+		}
+		loaders = append(loaders, loader)
+	}
+	return loaders, imp[i+1:]
+}
+
+// urlSchemeRe matches imports with a URL scheme, like data:text/javascript,... or https://esm.sh/react.
+var urlSchemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// hasURLScheme reports whether imp is a URL rather than a module specifier. These are inlined
+// or fetched at runtime, and npm package names cannot contain colons.
+func hasURLScheme(imp string) bool {
+	return urlSchemeRe.MatchString(imp)
+}
+
+// resolveOverride resolves imp through the resolve directives or custom resolvers.
+func (s *jslang) resolveOverride(c *config.Config, imp string, from label.Label) (label.Label, bool) {
+	if l, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: "js", Imp: imp}, "js"); ok {
+		return l, true
+	}
+	for _, resolver := range s.resolvers {
+		if l, ok := resolver.ResolveImport(c, imp, from); ok {
+			return l, true
+		}
+	}
+	return label.NoLabel, false
+}
+
+// includeTypeOnlyDeps reports whether type-only imports should become deps of a rule of kind.
+func includeTypeOnlyDeps(kind string, js *JsConfig) bool {
+	if js.TypeOnlyDeps != nil {
+		return *js.TypeOnlyDeps
+	}
+	return kind == "ts_project" || kind == "ts_library" || kind == "ts_declaration"
+}
+
+// isFirstParty reports whether a bare import refers to code in this repository rather than npm.
+func isFirstParty(imp string, from label.Label, js *JsConfig) bool {
+	if js.TsPaths.match(imp) != nil {
+		return true
+	}
+	if _, ok := scopeDir(imp, js); ok {
+		return true
+	}
+	if dep, _, ok := js.pnp.lookup(imp, from.Pkg); ok && dep.Workspace != "" {
+		return true
+	}
+	pkg, _ := js.packages.lookup(imp)
+	return pkg != nil
+}
+
+// publishedImport reports whether an import of a first-party package from outside of it
+// refers to a file published by the files allowlist of its package.json. Violations are
+// logged.
+func publishedImport(imp string, from label.Label, js *JsConfig) bool {
+	pkg, subpath := js.packages.lookup(imp)
+	if pkg == nil || pkg.contains(from.Pkg) {
+		return true
+	}
+	if target := pkg.resolveSubpath(subpath); target == "" || pkg.publishes(target) {
+		return true
+	}
+	log.Printf("Import %v for %s is not published by the files of %s.\n", imp, from.Abs(from.Repo, from.Pkg).String(), path.Join(pkg.Rel, "package.json"))
+	return false
+}
+
+// scopeDir maps an import of a scoped first-party package, e.g. @acme/ui/Button, to its
+// path in the repository according to the configured scope directories.
+func scopeDir(imp string, js *JsConfig) (string, bool) {
+	if !strings.HasPrefix(imp, "@") {
+		return "", false
+	}
+	parts := strings.SplitN(imp, "/", 2)
+	dir, ok := js.ScopeDirs[parts[0]]
+	if !ok || len(parts) < 2 {
+		return "", false
+	}
+	return path.Join(dir, parts[1]), true
+}
+
+// isLibraryKind reports whether kind is one of the non-test library rules this extension
+// generates that compile to code run by the babel runtime, so not declarations.
+func isLibraryKind(kind string, js *JsConfig) bool {
+	switch kind {
+	case js.JsLibrary.String(), "ts_project", "ts_library":
+		return true
+	}
+	return false
+}
+
+// resolveData resolves a file referenced at runtime to the rule providing it, e.g. a js_import,
+// or to the file itself if there is none.
+func resolveData(ix *resolve.RuleIndex, imp string, from label.Label, js *JsConfig) (label.Label, error) {
+	for _, candidate := range []string{imp, trimSourceExt(imp)} {
+		if l, err := resolveWithIndex(ix, candidate, from, js); err != notFoundError {
+			return l, err
+		}
+	}
+	return label.New(from.Repo, path.Dir(imp), path.Base(imp)), nil
+}
+
+// findManualMock finds the manual mock of an npm package mocked with jest.mock, which jest
+// expects in a __mocks__ directory next to node_modules, e.g. __mocks__/axios.js. Like
+// jest configs, it is looked up in the package of the test and all its parents.
+func findManualMock(mock string, ix *resolve.RuleIndex, from label.Label, js *JsConfig) (label.Label, error) {
+	if !isNpmDependency(mock) || hasURLScheme(mock) {
+		// Mocks of relative modules are next to the module itself
+		return label.NoLabel, notFoundError
+	}
+	for pkgDir := from.Pkg; pkgDir != ".."; pkgDir = path.Join(pkgDir, "..") {
+		if l, err := resolveWithIndex(ix, path.Join(pkgDir, "__mocks__", mock), from, js); err == nil {
+			return l, nil
+		}
+	}
+	return label.NoLabel, notFoundError
+}
+
+// resolveModule resolves the path of an import the way node does, where a file like
+// foo.js takes precedence over the index file of a directory foo.
+func resolveModule(ix *resolve.RuleIndex, imp string, from label.Label, js *JsConfig) (label.Label, error) {
+	if l, err := resolveWithIndex(ix, imp, from, js); err != notFoundError {
+		return l, err
+	}
+	return resolveDirectoryIndex(ix, imp, from, js)
+}
+
+// resolveDirectoryIndex resolves the import of a directory to its index file.
+func resolveDirectoryIndex(ix *resolve.RuleIndex, dir string, from label.Label, js *JsConfig) (label.Label, error) {
+	for _, indexFile := range indexFiles {
+		if l, err := resolveWithIndex(ix, path.Join(dir, indexFile), from, js); err != notFoundError {
+			return l, err
+		}
+	}
+	return label.NoLabel, notFoundError
 }
 
 // Note: Ideall this was not necessary and the jest rule would not need a jest config defined in the workspace
-func findJsConfig(configName string, ix *resolve.RuleIndex, from label.Label) (label.Label, error) {
+func findJsConfig(configName string, ix *resolve.RuleIndex, from label.Label, js *JsConfig) (label.Label, error) {
 	pkgDir := from.Pkg
 	for pkgDir != ".." {
 		imp := path.Join(pkgDir, configName+".config")
-		label, err := resolveWithIndex(ix, imp, from)
+		label, err := resolveWithIndex(ix, imp, from, js)
 		if err == nil {
 			return label, err
 		}
@@ -244,11 +754,85 @@ func hasPrefix(suffixes []string, x string) bool {
 	return false
 }
 
+// browserRemap applies the browser field of the package containing the importing file,
+// which may replace a module or file with another one. It returns the import and the
+// normalised path to resolve instead, or false if the browser field disables the import.
+func browserRemap(imp, normalisedImp string, ix *resolve.RuleIndex, from label.Label, js *JsConfig) (string, string, bool) {
+	pkg := js.packages.closest(from.Pkg)
+	if pkg == nil {
+		return imp, normalisedImp, true
+	}
+	replacement, ok := pkg.browserReplacement(imp, normalisedImp)
+	if !ok {
+		return imp, normalisedImp, true
+	}
+	target, ok := replacement.(string)
+	if !ok {
+		return "", "", false
+	}
+	if strings.HasPrefix(target, ".") {
+		return target, trimSourceExt(path.Join(pkg.Rel, target)), true
+	}
+	return target, normaliseImports(target, ix, from, js), true
+}
+
+// resolveInRootDirs returns the path of a relative import p in the first of the tsconfig
+// rootDirs it can be found in, if it is not in its own directory.
+func resolveInRootDirs(p string, ix *resolve.RuleIndex, from label.Label, js *JsConfig) string {
+	if len(js.TsRootDirs) == 0 {
+		return p
+	}
+	if _, err := resolveModule(ix, trimSourceExt(p), from, js); err != notFoundError {
+		return p
+	}
+	for _, candidate := range rootDirCandidates(js.TsRootDirs, p) {
+		if _, err := resolveModule(ix, trimSourceExt(candidate), from, js); err != notFoundError {
+			return candidate
+		}
+	}
+	return p
+}
+
 // normaliseImports ensures that relative imports or alias imports can all resolve to the same file
-func normaliseImports(imp string, ix *resolve.RuleIndex, from label.Label, aliasImportSupport bool) string {
+func normaliseImports(imp string, ix *resolve.RuleIndex, from label.Label, js *JsConfig) string {
 	// TODO: Handle directory imports, i.e. import/path/dir -> import/path/dir/index.js or import/path/dir/index.vue
 	// TODO: Should we also normalise imports that have an explicit '.js' file ending?
 	pkgDir := from.Pkg
+	aliasImportSupport := js.AliasImportSupport
+
+	// tsconfig paths take precedence, the first target that can be found in the index wins
+	if candidates := js.TsPaths.match(imp); len(candidates) > 0 {
+		for _, candidate := range candidates {
+			if _, err := resolveModule(ix, candidate, from, js); err != notFoundError {
+				return candidate
+			}
+		}
+		return candidates[0]
+	}
+
+	// Like tsc, bare imports are looked up relative to baseUrl before node_modules
+	if js.TsBaseURL != "" && !strings.HasPrefix(imp, ".") && !strings.HasPrefix(imp, "/") {
+		candidate := path.Join(js.TsBaseURL, imp)
+		if _, err := resolveModule(ix, trimSourceExt(candidate), from, js); err != notFoundError {
+			return candidate
+		}
+	}
+
+	// Imports of first-party packages are resolved through their package.json. They may
+	// refer to the compiled output of the package, which is mapped back to the sources.
+	if pkg, subpath := js.packages.lookup(imp); pkg != nil {
+		if target := pkg.resolveSubpath(subpath); target != "" {
+			return js.packages.sourcePath(target)
+		}
+	}
+	// Workspaces a Plug'n'Play install links to, possibly under an alias
+	if dep, subpath, ok := js.pnp.lookup(imp, pkgDir); ok && dep.Workspace != "" {
+		return js.packages.sourcePath(path.Join(dep.Workspace, subpath))
+	}
+	if dir, ok := scopeDir(imp, js); ok {
+		return js.packages.sourcePath(dir)
+	}
+
 	// TODO: Right now we assume @/ and ~~ to simply be an alias for imports from the root, but that might not be true.
 	// Also need to support ~ aliases which is even more tricky
 	if aliasImportSupport && strings.HasPrefix(imp, "@/") {
@@ -261,10 +845,10 @@ func normaliseImports(imp string, ix *resolve.RuleIndex, from label.Label, alias
 
 	if aliasImportSupport && strings.HasPrefix(imp, "~/") {
 		// TODO: Figure out if we want to ignore any config files found at root
-		l, err := findJsConfig("nuxt", ix, from)
+		l, err := findJsConfig("nuxt", ix, from, js)
 		configFound := "nuxt"
 		if err != nil {
-			l, err = findJsConfig("vue", ix, from)
+			l, err = findJsConfig("vue", ix, from, js)
 			configFound = "vue"
 		}
 
@@ -281,11 +865,11 @@ func normaliseImports(imp string, ix *resolve.RuleIndex, from label.Label, alias
 	}
 
 	if strings.HasPrefix(imp, "../") {
-		return path.Join(pkgDir, imp)
+		return resolveInRootDirs(path.Join(pkgDir, imp), ix, from, js)
 	}
 
 	if strings.HasPrefix(imp, ".") {
-		return path.Join(pkgDir, imp)
+		return resolveInRootDirs(path.Join(pkgDir, imp), ix, from, js)
 	}
 	if strings.HasPrefix(imp, "src/design-system/theme") && pkgDir == "benchsci/frontend/reagent/.storybook" && from.Name == "preview" {
 		return "benchsci/frontend/reagent/src/design-system/theme"
@@ -314,21 +898,116 @@ func normaliseImports(imp string, ix *resolve.RuleIndex, from label.Label, alias
 	return imp
 }
 
-func resolveWithIndex(ix *resolve.RuleIndex, imp string, from label.Label) (label.Label, error) {
+// cssModule reports whether imp is a stylesheet imported as a CSS module script, which
+// depends on the stylesheet instead of shipping it as data.
+func cssModule(imp string, info FileInfo) bool {
+	return containsString(info.CSSModules, imp)
+}
+
+// importsDict returns the dict of the imports of a rule and the labels they resolved to
+// for js_persist_imports, sorted by import so it reads back unchanged.
+func importsDict(resolved map[string]string) *bzl.DictExpr {
+	imps := make([]string, 0, len(resolved))
+	for imp := range resolved {
+		imps = append(imps, imp)
+	}
+	sort.Strings(imps)
+	dict := &bzl.DictExpr{ForceMultiLine: true}
+	for _, imp := range imps {
+		dict.List = append(dict.List, &bzl.KeyValueExpr{
+			Key:   &bzl.StringExpr{Value: imp},
+			Value: &bzl.StringExpr{Value: resolved[imp]},
+		})
+	}
+	return dict
+}
+
+// resolveCrossLang resolves imp to the rule of the language lang indexed with it, with or
+// without its extension, for imports that js_cross_lang_import hands over to other languages.
+func resolveCrossLang(ix *resolve.RuleIndex, lang, imp string, from label.Label) (label.Label, error) {
+	for _, candidate := range []string{imp, strings.TrimSuffix(imp, path.Ext(imp))} {
+		matches := ix.FindRulesByImport(resolve.ImportSpec{Lang: lang, Imp: candidate}, lang)
+		if len(matches) > 1 {
+			return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
+		}
+		if len(matches) == 1 {
+			if matches[0].IsSelfImport(from) {
+				return label.NoLabel, skipImportError
+			}
+			return matches[0].Label, nil
+		}
+	}
+	return label.NoLabel, notFoundError
+}
+
+// ruleResolved counts a generated rule as resolved. Once the last one is, the run is done
+// resolving, the npm deps report is written and the run fails if imports were left
+// unresolved with js_strict_resolution.
+func ruleResolved(js *JsConfig) {
+	if js.pendingRules == nil {
+		return
+	}
+	*js.pendingRules--
+	if *js.pendingRules > 0 {
+		return
+	}
+	if err := js.npmDeps.write(); err != nil {
+		log.Print(err)
+	}
+	if js.unresolved != nil && len(*js.unresolved) > 0 {
+		for _, err := range *js.unresolved {
+			log.Print(err)
+		}
+		log.Fatalf("%d imports could not be resolved", len(*js.unresolved))
+	}
+}
+
+func resolveWithIndex(ix *resolve.RuleIndex, imp string, from label.Label, js *JsConfig) (label.Label, error) {
 	res := resolve.ImportSpec{
 		Lang: "js",
 		Imp:  imp,
 	}
-	matches := ix.FindRulesByImport(res, "js")
+	matches := findRulesByImport(ix, res, js)
 	if len(matches) == 0 {
 		return label.NoLabel, notFoundError
 	}
-	if len(matches) > 1 {
-		//return matches[1].Label, nil
-		return label.NoLabel, fmt.Errorf("multiple rules (%s and %s) may be imported with %q from %s", matches[0].Label, matches[1].Label, imp, from)
-	}
-	if matches[0].IsSelfImport(from) {
+	match := preferredMatch(matches, imp)
+	if match.IsSelfImport(from) {
 		return label.NoLabel, skipImportError
 	}
-	return matches[0].Label, nil
+	return match.Label, nil
+}
+
+// findRulesByImport finds the rules that may be imported with imp in the index and among
+// the rules of mapped kinds.
+func findRulesByImport(ix *resolve.RuleIndex, imp resolve.ImportSpec, js *JsConfig) []resolve.FindResult {
+	return append(ix.FindRulesByImport(imp, imp.Lang), js.mappedImports[imp]...)
+}
+
+// preferredMatch breaks the tie between several rules that may be imported with imp. The
+// rule named after the imported file in its directory wins, followed by the lexicographically
+// smallest label, so the choice doesn't depend on the order the rules were indexed in. As
+// the latter is arbitrary, it is logged with the candidates.
+func preferredMatch(matches []resolve.FindResult, imp string) resolve.FindResult {
+	best := matches[0]
+	for _, m := range matches[1:] {
+		mExact, bestExact := exactMatch(m.Label, imp), exactMatch(best.Label, imp)
+		if mExact && !bestExact || mExact == bestExact && m.Label.String() < best.Label.String() {
+			best = m
+		}
+	}
+	if len(matches) > 1 && !exactMatch(best.Label, imp) {
+		candidates := make([]string, len(matches))
+		for i, m := range matches {
+			candidates[i] = m.Label.String()
+		}
+		sort.Strings(candidates)
+		log.Printf("Import %v may resolve to any of %s, using %s", imp, strings.Join(candidates, ", "), best.Label)
+	}
+	return best
+}
+
+// exactMatch reports whether l is the rule named after the file imported with imp.
+func exactMatch(l label.Label, imp string) bool {
+	return l.Pkg == path.Dir(imp) && l.Name == path.Base(imp)
 }
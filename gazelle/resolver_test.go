@@ -1,10 +1,20 @@
 package gazelle
 
 import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 const (
@@ -37,7 +47,7 @@ func TestNormalisePath(t *testing.T) {
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), true)
+			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), &JsConfig{AliasImportSupport: true})
 
 			if got != tc.want {
 				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
@@ -72,7 +82,7 @@ func TestNormalisePathNoAlias(t *testing.T) {
 		},
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
-			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), false)
+			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), &JsConfig{})
 
 			if got != tc.want {
 				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
@@ -80,3 +90,654 @@ func TestNormalisePathNoAlias(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalisePathTsPaths(t *testing.T) {
+	js := &JsConfig{
+		TsPaths: tsPathMappings{
+			{Pattern: "@app/*", Targets: []string{"web/src/app/*"}},
+			{Pattern: "@config", Targets: []string{"web/src/config"}},
+		},
+	}
+	for _, tc := range []struct {
+		desc, path, want string
+	}{
+		{
+			desc: "exact mapping",
+			path: "@config",
+			want: "web/src/config",
+		},
+		{
+			desc: "wildcard mapping",
+			path: "@app/user",
+			want: "web/src/app/user",
+		},
+		{
+			desc: "unmapped",
+			path: "lodash",
+			want: "lodash",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), js)
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalisePathScopeDirs(t *testing.T) {
+	js := &JsConfig{
+		ScopeDirs: map[string]string{"@acme": "packages"},
+	}
+	for _, tc := range []struct {
+		desc, path, want string
+	}{
+		{
+			desc: "scoped package",
+			path: "@acme/ui",
+			want: "packages/ui",
+		},
+		{
+			desc: "file in scoped package",
+			path: "@acme/ui/Button",
+			want: "packages/ui/Button",
+		},
+		{
+			desc: "other scope",
+			path: "@babel/runtime/helpers",
+			want: "@babel/runtime/helpers",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", pkgDir, "name"), js)
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIncludeTypeOnlyDeps(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		desc, kind   string
+		typeOnlyDeps *bool
+		want         bool
+	}{
+		{desc: "ts_project default", kind: "ts_project", want: true},
+		{desc: "js_library default", kind: "js_library", want: false},
+		{desc: "js_library enabled", kind: "js_library", typeOnlyDeps: &yes, want: true},
+		{desc: "ts_project disabled", kind: "ts_project", typeOnlyDeps: &no, want: false},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := includeTypeOnlyDeps(tc.kind, &JsConfig{TypeOnlyDeps: tc.typeOnlyDeps})
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalisePathCompiledOutput(t *testing.T) {
+	js := &JsConfig{
+		ScopeDirs: map[string]string{"@acme": "packages"},
+		packages:  newPackageRegistry(),
+	}
+	js.packages.addOutDir("packages/b/dist", "packages/b/src")
+	for _, tc := range []struct {
+		desc, path, want string
+	}{
+		{
+			desc: "compiled output of scoped package",
+			path: "@acme/b/dist/utils",
+			want: "packages/b/src/utils",
+		},
+		{
+			desc: "sources of scoped package",
+			path: "@acme/b/src/utils",
+			want: "packages/b/src/utils",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := normaliseImports(tc.path, &resolve.RuleIndex{}, label.New("repo", "packages/a/src", "name"), js)
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+// virtualResolver is an example ImportResolver, mapping imports of virtual:<name>
+// to the rules generating those modules in //virtual.
+type virtualResolver struct{}
+
+func (virtualResolver) ResolveImport(c *config.Config, imp string, from label.Label) (label.Label, bool) {
+	if !strings.HasPrefix(imp, "virtual:") {
+		return label.NoLabel, false
+	}
+	return label.New("", "virtual", strings.TrimPrefix(imp, "virtual:")), true
+}
+
+// newResolveConfig returns the config of a run with js and the resolve directives of
+// gazelle, which Resolve consults before anything else.
+func newResolveConfig(t *testing.T, js *JsConfig) *config.Config {
+	c := config.New()
+	cr := &resolve.Configurer{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cr.RegisterFlags(fs, "update", c)
+	if err := cr.CheckFlags(fs, c); err != nil {
+		t.Fatal(err)
+	}
+	cr.Configure(c, "", nil)
+	c.Exts[extName] = js
+	return c
+}
+
+func TestResolveCustomResolver(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm"}
+	c := newResolveConfig(t, js)
+	f, err := rule.LoadData("BUILD.bazel", "", []byte("# gazelle:resolve js virtual:icons //third_party:icons\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&resolve.Configurer{}).Configure(c, "", f)
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	r := rule.NewRule("js_library", "main")
+	info := FileInfo{Imports: []string{"lodash", "virtual:icons", "virtual:routes"}}
+
+	NewLanguage(virtualResolver{}).(*jslang).Resolve(c, ix, nil, r, info, label.New("", "app", "main"))
+
+	if got, want := r.AttrStrings("deps"), []string{"//third_party:icons", "//virtual:routes", "@npm//lodash"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestResolveURLImports(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm"}
+	c := newResolveConfig(t, js)
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	r := rule.NewRule("js_library", "main")
+	info := FileInfo{Imports: []string{
+		"blob:https://example.com/5a1c",
+		"data:text/javascript,export default 42",
+		"https://esm.sh/react@18",
+		"http://localhost:8080/module.js",
+		"lodash",
+	}}
+
+	NewLanguage().(*jslang).Resolve(c, ix, nil, r, info, label.New("", "app", "main"))
+
+	if got, want := r.AttrStrings("deps"), []string{"@npm//lodash"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestResolveFileBeforeDirectoryIndex(t *testing.T) {
+	js := &JsConfig{
+		NpmWorkspaceName: "npm",
+		TsPaths:          tsPathMappings{{Pattern: "@shared", Targets: []string{"app/shared", "lib/shared"}}},
+	}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	for _, src := range []string{"app/foo.js", "app/foo/index.js", "lib/shared/index.js"} {
+		r := rule.NewRule("js_library", trimSourceExt(path.Base(src)))
+		r.SetAttr("srcs", []string{path.Base(src)})
+		ix.AddRule(c, r, &rule.File{Pkg: path.Dir(src)})
+	}
+	ix.Finish()
+	for _, tc := range []struct {
+		imp  string
+		want []string
+	}{
+		{imp: "./foo", want: []string{":foo"}},
+		{imp: "./foo/index", want: []string{"//app/foo:index"}},
+		{imp: "@shared", want: []string{"//lib/shared:index"}},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			r := rule.NewRule("js_library", "main")
+
+			lang.Resolve(c, ix, nil, r, FileInfo{Imports: []string{tc.imp}}, label.New("", "app", "main"))
+
+			if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveTsPathsVirtualPackage(t *testing.T) {
+	js := &JsConfig{
+		NpmWorkspaceName: "npm",
+		TsPaths: tsPathMappings{
+			{Pattern: "@app", Targets: []string{"app/src/index.ts"}},
+			{Pattern: "@app/*", Targets: []string{"app/src/*"}},
+			{Pattern: "@ui", Targets: []string{"ui/src/public.tsx"}},
+		},
+	}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	for _, src := range []string{"app/src/index.ts", "app/src/api.ts", "ui/src/public.tsx"} {
+		r := rule.NewRule("ts_project", trimSourceExt(path.Base(src)))
+		r.SetAttr("srcs", []string{path.Base(src)})
+		ix.AddRule(c, r, &rule.File{Pkg: path.Dir(src)})
+	}
+	ix.Finish()
+	for _, tc := range []struct {
+		imp  string
+		want []string
+	}{
+		{imp: "@app", want: []string{"//app/src:index"}},
+		{imp: "@app/api", want: []string{"//app/src:api"}},
+		{imp: "@ui", want: []string{"//ui/src:public"}},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			r := rule.NewRule("ts_project", "main")
+
+			lang.Resolve(c, ix, nil, r, FileInfo{Imports: []string{tc.imp}}, label.New("", "web", "main"))
+
+			if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestImportsAlias(t *testing.T) {
+	for _, tc := range []struct {
+		desc         string
+		indexAliases bool
+		actual       interface{}
+		want         []resolve.ImportSpec
+	}{
+		{
+			desc:         "indexed",
+			indexAliases: true,
+			actual:       "//lib/internal:button",
+			want:         []resolve.ImportSpec{{Lang: "js", Imp: "lib/ui"}},
+		},
+		{
+			desc:         "disabled",
+			indexAliases: false,
+			actual:       "//lib/internal:button",
+			want:         nil,
+		},
+		{
+			desc:         "no actual",
+			indexAliases: true,
+			want:         nil,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			c := &config.Config{Exts: map[string]interface{}{extName: &JsConfig{IndexAliases: tc.indexAliases}}}
+			r := rule.NewRule("alias", "ui")
+			if tc.actual != nil {
+				r.SetAttr("actual", tc.actual)
+			}
+
+			got := NewLanguage().(*jslang).Imports(c, r, &rule.File{Pkg: "lib"})
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveManualMocks(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm"}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	for _, src := range []string{"__mocks__/axios.js", "app/__mocks__/@acme/analytics.js"} {
+		r := rule.NewRule("js_library", trimSourceExt(path.Base(src)))
+		r.SetAttr("srcs", []string{path.Base(src)})
+		ix.AddRule(c, r, &rule.File{Pkg: path.Dir(src)})
+	}
+	ix.Finish()
+	r := rule.NewRule("jest_test", "api.test")
+	info := FileInfo{
+		Imports: []string{"axios"},
+		Mocks:   []string{"./cache", "@acme/analytics", "axios", "lodash"},
+	}
+
+	lang.Resolve(c, ix, nil, r, info, label.New("", "app/api", "api.test"))
+
+	want := []string{"//__mocks__:axios", "//app/__mocks__/@acme:analytics", "@npm//axios"}
+	if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestResolveTsBaseURL(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm", TsBaseURL: "web/src"}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	for _, src := range []string{"web/src/utils/format.ts", "web/src/config/index.ts"} {
+		r := rule.NewRule("ts_project", trimSourceExt(path.Base(src)))
+		r.SetAttr("srcs", []string{path.Base(src)})
+		ix.AddRule(c, r, &rule.File{Pkg: path.Dir(src)})
+	}
+	ix.Finish()
+	for _, tc := range []struct {
+		imp  string
+		want []string
+	}{
+		{imp: "utils/format", want: []string{"//web/src/utils:format"}},
+		{imp: "config", want: []string{"//web/src/config:index"}},
+		{imp: "date-fns/format", want: []string{"@npm//date-fns"}},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			r := rule.NewRule("ts_project", "main")
+
+			lang.Resolve(c, ix, nil, r, FileInfo{Imports: []string{tc.imp}}, label.New("", "web/src/app", "main"))
+
+			if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripVueQuery(t *testing.T) {
+	for _, tc := range []struct {
+		imp, want string
+	}{
+		{imp: "./Foo.vue?vue&type=script&lang.ts", want: "./Foo.vue"},
+		{imp: "./Foo.vue?vue&type=style&index=0&scoped=true&lang.css", want: "./Foo.vue"},
+		{imp: "./Foo.vue?vue", want: "./Foo.vue"},
+		{imp: "./Foo.vue", want: "./Foo.vue"},
+		{imp: "./Foo.vue?raw", want: "./Foo.vue?raw"},
+		{imp: "./icon.svg?vue&component", want: "./icon.svg?vue&component"},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			if got := stripVueQuery(tc.imp); got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMigratedSibling(t *testing.T) {
+	var rules []*rule.Rule
+	for _, src := range []string{"foo.js", "foo.ts", "bar.js", "types.d.ts", "types.js"} {
+		r := rule.NewRule("js_library", src)
+		r.SetAttr("srcs", []string{src})
+		rules = append(rules, r)
+	}
+	f := &rule.File{Pkg: "lib", Rules: rules}
+	for _, tc := range []struct {
+		prefer, src string
+		want        bool
+	}{
+		{prefer: "ts", src: "foo.js", want: true},
+		{prefer: "ts", src: "foo.ts", want: false},
+		{prefer: "js", src: "foo.ts", want: true},
+		{prefer: "js", src: "foo.js", want: false},
+		{prefer: "ts", src: "bar.js", want: false},
+		{prefer: "ts", src: "types.js", want: false},
+		{prefer: "js", src: "types.d.ts", want: false},
+		{prefer: "", src: "foo.js", want: false},
+	} {
+		t.Run(tc.prefer+" "+tc.src, func(t *testing.T) {
+			got := migratedSibling(tc.src, f, &JsConfig{MigrationPrefer: tc.prefer})
+
+			if got != tc.want {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+// wasmResolver indexes the wasm-bindgen rules of another language by their output.
+type wasmResolver struct{}
+
+func (wasmResolver) Name() string { return "rust" }
+
+func (wasmResolver) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resolve.ImportSpec {
+	return []resolve.ImportSpec{{Lang: "rust", Imp: path.Join(f.Pkg, r.Name()+"_bg.wasm")}}
+}
+
+func (wasmResolver) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+
+func (wasmResolver) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports interface{}, from label.Label) {
+}
+
+func TestResolveCrossLangImports(t *testing.T) {
+	js := &JsConfig{
+		NpmWorkspaceName: "npm",
+		CrossLangImports: []crossLangImport{{Pattern: "crates/*/*_bg.wasm", Lang: "rust"}},
+	}
+	c := newResolveConfig(t, js)
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return wasmResolver{} })
+	r := rule.NewRule("rust_wasm_bindgen", "image")
+	ix.AddRule(c, r, &rule.File{Pkg: "crates/image"})
+	ix.Finish()
+	r = rule.NewRule("js_library", "main")
+	info := FileInfo{Imports: []string{"../../crates/image/image_bg.wasm", "../../crates/missing/missing_bg.wasm", "lodash"}}
+
+	NewLanguage().(*jslang).Resolve(c, ix, nil, r, info, label.New("", "web/app", "main"))
+
+	if got, want := r.AttrStrings("deps"), []string{"//crates/image", "@npm//lodash"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestCrossLangImport(t *testing.T) {
+	js := &JsConfig{CrossLangImports: []crossLangImport{
+		{Pattern: "crates/*/pkg/*.js", Lang: "rust"},
+		{Pattern: "*.wasm", Lang: "go"},
+	}}
+	for _, tc := range []struct {
+		imp, want string
+		ok        bool
+	}{
+		{imp: "./module.wasm", want: "go", ok: true},
+		{imp: "crates/image/pkg/image.js", want: "rust", ok: true},
+		{imp: "./module.js"},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			got, ok := js.crossLangImport(tc.imp)
+
+			if got != tc.want || ok != tc.ok {
+				t.Errorf("Inequalith.\ngot  %#v, %v;\nwant %#v, %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestUnresolvedImport(t *testing.T) {
+	from := label.New("", "app", "main")
+	for _, tc := range []struct {
+		desc    string
+		js      *JsConfig
+		imp     string
+		wantErr bool
+	}{
+		{
+			desc: "not strict",
+			js:   &JsConfig{},
+			imp:  "./missing",
+		},
+		{
+			desc:    "strict",
+			js:      &JsConfig{StrictResolution: true},
+			imp:     "./missing",
+			wantErr: true,
+		},
+		{
+			desc: "allowed",
+			js:   &JsConfig{StrictResolution: true, AllowUnresolved: []string{"virtual:*", "./generated/*"}},
+			imp:  "./generated/schema",
+		},
+		{
+			desc:    "not allowed",
+			js:      &JsConfig{StrictResolution: true, AllowUnresolved: []string{"virtual:*", "./generated/*"}},
+			imp:     "./generated/nested/schema",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.js.unresolvedImport(tc.imp, from)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Inequalith.\ngot  %v;\nwant error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveConnectEs(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm"}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	proto := rule.NewRule("proto_library", "eliza_proto")
+	proto.SetAttr("srcs", []string{"eliza.proto"})
+	gen := rule.NewRule("ts_proto_library", "eliza_ts_proto")
+	gen.SetAttr("proto", ":eliza_proto")
+	ix.AddRule(c, gen, &rule.File{Pkg: "proto/eliza/v1", Rules: []*rule.Rule{proto, gen}})
+	external := rule.NewRule("ts_proto_library", "health_ts_proto")
+	external.SetAttr("proto", "@grpc//health/v1:health_proto")
+	ix.AddRule(c, external, &rule.File{Pkg: "proto/health", Rules: []*rule.Rule{external}})
+	ix.Finish()
+	r := rule.NewRule("ts_project", "client")
+	info := FileInfo{Imports: []string{
+		"../proto/eliza/v1/eliza_connect",
+		"../proto/eliza/v1/eliza_pb",
+		"../proto/health/health_pb",
+		"@connectrpc/connect",
+	}}
+
+	lang.Resolve(c, ix, nil, r, info, label.New("", "app", "client"))
+
+	want := []string{"//proto/eliza/v1:eliza_ts_proto", "//proto/health:health_ts_proto", "@npm//@connectrpc/connect"}
+	if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestSplitInlineLoaders(t *testing.T) {
+	for _, tc := range []struct {
+		imp, wantResource string
+		wantLoaders       []string
+	}{
+		{imp: "./worker.js", wantResource: "./worker.js"},
+		{imp: "worker-loader!./worker.js", wantResource: "./worker.js", wantLoaders: []string{"worker-loader"}},
+		{imp: "style-loader!css-loader?modules&importLoaders=1!./styles.css", wantResource: "./styles.css", wantLoaders: []string{"style-loader", "css-loader"}},
+		{imp: "!!raw-loader!../README.md", wantResource: "../README.md", wantLoaders: []string{"raw-loader"}},
+		{imp: "-!@acme/loaders/svg!./icon.svg", wantResource: "./icon.svg", wantLoaders: []string{"@acme/loaders"}},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			loaders, resource := splitInlineLoaders(tc.imp)
+
+			if !reflect.DeepEqual(loaders, tc.wantLoaders) || resource != tc.wantResource {
+				t.Errorf("Inequalith.\ngot  %#v, %#v;\nwant %#v, %#v", loaders, resource, tc.wantLoaders, tc.wantResource)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguousImports(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm"}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	for _, tc := range []struct{ name, src string }{
+		{"legacy", "button.js"},
+		{"button", "button.ts"},
+		{"widgets_b", "widget.js"},
+		{"widgets_a", "widget.ts"},
+	} {
+		r := rule.NewRule("js_library", tc.name)
+		r.SetAttr("srcs", []string{tc.src})
+		ix.AddRule(c, r, &rule.File{Pkg: "ui"})
+	}
+	ix.Finish()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	for _, tc := range []struct {
+		imp     string
+		want    []string
+		wantLog string
+	}{
+		{imp: "../ui/button", want: []string{"//ui:button"}},
+		{imp: "../ui/widget", want: []string{"//ui:widgets_a"}, wantLog: "any of //ui:widgets_a, //ui:widgets_b, using //ui:widgets_a"},
+	} {
+		t.Run(tc.imp, func(t *testing.T) {
+			logs.Reset()
+			r := rule.NewRule("js_library", "main")
+
+			lang.Resolve(c, ix, nil, r, FileInfo{Imports: []string{tc.imp}}, label.New("", "app", "main"))
+
+			if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+			if got := logs.String(); tc.wantLog == "" && got != "" || !strings.Contains(got, tc.wantLog) {
+				t.Errorf("Inequalith.\ngot log  %q;\nwant log %q", got, tc.wantLog)
+			}
+		})
+	}
+}
+
+func TestResolveDepsAttr(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm", DepsAttr: "dependencies"}
+	c := newResolveConfig(t, js)
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	r := rule.NewRule("js_library", "main")
+	r.SetAttr("dependencies", []string{"@npm//moment"})
+
+	NewLanguage().(*jslang).Resolve(c, ix, nil, r, FileInfo{Imports: []string{"date-fns"}}, label.New("", "app", "main"))
+
+	if got, want := r.AttrStrings("dependencies"), []string{"@npm//date-fns"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+	if r.Attr("deps") != nil {
+		t.Errorf("deps set to %#v", r.AttrStrings("deps"))
+	}
+}
+
+func TestResolveTypesTarget(t *testing.T) {
+	js := &JsConfig{NpmWorkspaceName: "npm", TypesTarget: "{name}_types"}
+	c := newResolveConfig(t, js)
+	lang := NewLanguage()
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, f *rule.File) resolve.Resolver { return lang })
+	models := rule.NewRule("ts_project", "models")
+	models.SetAttr("srcs", []string{"models.ts"})
+	types := rule.NewRule("filegroup", "models_types")
+	types.SetAttr("srcs", []string{":models"})
+	types.SetAttr("output_group", "types")
+	ix.AddRule(c, models, &rule.File{Pkg: "lib/models", Rules: []*rule.Rule{models, types}})
+	api := rule.NewRule("ts_project", "api")
+	api.SetAttr("srcs", []string{"api.ts"})
+	ix.AddRule(c, api, &rule.File{Pkg: "lib/api", Rules: []*rule.Rule{api}})
+	auth := rule.NewRule("ts_project", "auth")
+	auth.SetAttr("srcs", []string{"auth.ts"})
+	authTypes := rule.NewRule("filegroup", "auth_types")
+	ix.AddRule(c, auth, &rule.File{Pkg: "lib/auth", Rules: []*rule.Rule{auth, authTypes}})
+	ix.Finish()
+	r := rule.NewRule("ts_project", "app")
+	info := FileInfo{
+		Imports:            []string{"../lib/api/api", "../lib/auth/auth", "../lib/models/models"},
+		DeclarationImports: []string{"../lib/api/api", "../lib/models/models"},
+	}
+
+	lang.Resolve(c, ix, nil, r, info, label.New("", "app", "app"))
+
+	// api has no declaration sub-target and auth is also imported as a value
+	want := []string{"//lib/api", "//lib/auth", "//lib/models:models_types"}
+	if got := r.AttrStrings("deps"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// tsConfigFile is the subset of a tsconfig.json that affects import resolution.
+type tsConfigFile struct {
+	CompilerOptions struct {
+		BaseURL  string              `json:"baseUrl"`
+		Paths    map[string][]string `json:"paths"`
+		OutDir   string              `json:"outDir"`
+		RootDir  string              `json:"rootDir"`
+		RootDirs []string            `json:"rootDirs"`
+	} `json:"compilerOptions"`
+}
+
+// tsPathMapping is a single entry of the tsconfig "paths" map. Targets are
+// relative to the repository root and have their source extension removed, so
+// they can be looked up in the rule index directly.
+type tsPathMapping struct {
+	Pattern string
+	Targets []string
+}
+
+// tsPathMappings holds all "paths" entries of a tsconfig.json, sorted by pattern.
+type tsPathMappings []tsPathMapping
+
+// loadTsConfig reads the tsconfig.json in dir, if there is one. rel is the
+// slash-separated path of dir relative to the repository root.
+func loadTsConfig(dir, rel string) (*tsConfigFile, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "tsconfig.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var tsconfig tsConfigFile
+	if err := json.Unmarshal(stripJSONComments(content), &tsconfig); err != nil {
+		return nil, fmt.Errorf("%s: error parsing tsconfig.json: %v", path.Join(rel, "tsconfig.json"), err)
+	}
+	return &tsconfig, nil
+}
+
+// pathMappings returns the "paths" of the tsconfig with all targets made
+// relative to the repository root. rel is the directory of the tsconfig.json.
+func (tsconfig *tsConfigFile) pathMappings(rel string) tsPathMappings {
+	if len(tsconfig.CompilerOptions.Paths) == 0 {
+		return nil
+	}
+	// Paths are resolved relative to baseUrl, or to the tsconfig itself if no baseUrl is set
+	base := path.Join(rel, tsconfig.CompilerOptions.BaseURL)
+	var mappings tsPathMappings
+	for pattern, targets := range tsconfig.CompilerOptions.Paths {
+		mapping := tsPathMapping{Pattern: pattern}
+		for _, target := range targets {
+			mapping.Targets = append(mapping.Targets, trimSourceExt(path.Join(base, target)))
+		}
+		mappings = append(mappings, mapping)
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Pattern < mappings[j].Pattern })
+	return mappings
+}
+
+// rootDirs returns the "rootDirs" of the tsconfig relative to the repository root.
+// rel is the directory of the tsconfig.json.
+func (tsconfig *tsConfigFile) rootDirs(rel string) []string {
+	var dirs []string
+	for _, dir := range tsconfig.CompilerOptions.RootDirs {
+		dirs = append(dirs, path.Join(rel, dir))
+	}
+	return dirs
+}
+
+// rootDirCandidates returns the paths p refers to in the other roots of rootDirs, which
+// tsc merges into a single virtual directory, e.g. generated/foo for src/foo. p has to be
+// in one of the roots, otherwise there are none.
+func rootDirCandidates(rootDirs []string, p string) []string {
+	var root string
+	for _, dir := range rootDirs {
+		if (p == dir || strings.HasPrefix(p, dir+"/")) && len(dir) > len(root) {
+			root = dir
+		}
+	}
+	if root == "" {
+		return nil
+	}
+	var candidates []string
+	for _, dir := range rootDirs {
+		if dir != root {
+			candidates = append(candidates, path.Join(dir, strings.TrimPrefix(p, root)))
+		}
+	}
+	return candidates
+}
+
+// match returns the candidate paths imp maps to, in the order they should be tried.
+// Like tsc, an exact pattern is preferred over a wildcard one, and among wildcard
+// patterns the one with the longest prefix wins. Patterns with a trailing slash
+// like "@app/" are directory prefixes, which compete with wildcards on length.
+func (mappings tsPathMappings) match(imp string) []string {
+	var best *tsPathMapping
+	var bestPrefix, bestWildcard string
+	for i, mapping := range mappings {
+		var prefix, suffix string
+		if star := strings.Index(mapping.Pattern, "*"); star >= 0 {
+			prefix, suffix = mapping.Pattern[:star], mapping.Pattern[star+1:]
+		} else if mapping.Pattern == imp {
+			return mapping.Targets
+		} else if strings.HasSuffix(mapping.Pattern, "/") {
+			prefix = mapping.Pattern
+		} else {
+			continue
+		}
+		if len(imp) < len(prefix)+len(suffix) || !strings.HasPrefix(imp, prefix) || !strings.HasSuffix(imp, suffix) {
+			continue
+		}
+		if best == nil || len(prefix) > len(bestPrefix) {
+			best = &mappings[i]
+			bestPrefix = prefix
+			bestWildcard = imp[len(prefix) : len(imp)-len(suffix)]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	candidates := make([]string, len(best.Targets))
+	for i, target := range best.Targets {
+		if strings.Contains(best.Pattern, "*") {
+			candidates[i] = strings.Replace(target, "*", bestWildcard, 1)
+		} else {
+			// Targets of directory prefixes lost their trailing slash to path.Join
+			candidates[i] = path.Join(target, bestWildcard)
+		}
+	}
+	return candidates
+}
+
+// trimSourceExt removes a js/ts source extension from p, as the index stores imports without them.
+func trimSourceExt(p string) string {
+	switch path.Ext(p) {
+	case ".js", ".jsx", ".ts", ".tsx", ".vue", ".mdx":
+		return strings.TrimSuffix(p, path.Ext(p))
+	}
+	return p
+}
+
+// stripJSONComments removes // and /* */ comments as well as trailing commas,
+// which tsconfig.json files commonly contain but encoding/json rejects.
+func stripJSONComments(content []byte) []byte {
+	out := make([]byte, 0, len(content))
+	inString := false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(content) {
+				i++
+				out = append(out, content[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			if i < len(content) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			i += 2
+			for i+1 < len(content) && !(content[i] == '*' && content[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == '}' || c == ']':
+			// Drop a trailing comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && strings.ContainsRune(" \t\r\n", rune(out[j])) {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
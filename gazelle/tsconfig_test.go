@@ -0,0 +1,156 @@
+/* Copyright 2019 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gazelle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TEMPDIR"), "TestLoadTsConfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := `{
+  // Comments and trailing commas are allowed in tsconfig.json
+  "compilerOptions": {
+    "baseUrl": ".", /* relative to this file */
+    "paths": {
+      "@config": ["src/config.ts"],
+      "@app/*": ["src/app/*", "generated/app/*"],
+    },
+  },
+}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "tsconfig.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tsconfig, err := loadTsConfig(dir, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tsconfig.pathMappings("web")
+	want := tsPathMappings{
+		{Pattern: "@app/*", Targets: []string{"web/src/app/*", "web/generated/app/*"}},
+		{Pattern: "@config", Targets: []string{"web/src/config"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestTsPathMappingsMatch(t *testing.T) {
+	mappings := tsPathMappings{
+		{Pattern: "*", Targets: []string{"src/*"}},
+		{Pattern: "@app/*", Targets: []string{"src/app/*", "generated/app/*"}},
+		{Pattern: "@app/config", Targets: []string{"src/config/prod"}},
+		{Pattern: "@assets/", Targets: []string{"static/assets"}},
+		{Pattern: "@assets/icons/", Targets: []string{"static/icons"}},
+		{Pattern: "@config", Targets: []string{"src/config"}},
+		{Pattern: "@lib/*/styles", Targets: []string{"src/lib/*/css"}},
+	}
+	for _, tc := range []struct {
+		desc, imp string
+		want      []string
+	}{
+		{
+			desc: "exact",
+			imp:  "@config",
+			want: []string{"src/config"},
+		},
+		{
+			desc: "exact does not match prefix",
+			imp:  "@config/other",
+			want: []string{"src/@config/other"},
+		},
+		{
+			desc: "wildcard",
+			imp:  "@app/user/profile",
+			want: []string{"src/app/user/profile", "generated/app/user/profile"},
+		},
+		{
+			desc: "exact preferred over overlapping wildcard",
+			imp:  "@app/config",
+			want: []string{"src/config/prod"},
+		},
+		{
+			desc: "wildcard with suffix",
+			imp:  "@lib/button/styles",
+			want: []string{"src/lib/button/css"},
+		},
+		{
+			desc: "trailing slash directory prefix",
+			imp:  "@assets/img/logo",
+			want: []string{"static/assets/img/logo"},
+		},
+		{
+			desc: "longest trailing slash prefix wins",
+			imp:  "@assets/icons/close",
+			want: []string{"static/icons/close"},
+		},
+		{
+			desc: "longest prefix wins",
+			imp:  "@app/x",
+			want: []string{"src/app/x", "generated/app/x"},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := mappings.match(tc.imp)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+	if got := (tsPathMappings{{Pattern: "@config", Targets: []string{"src/config"}}}).match("lodash"); got != nil {
+		t.Errorf("expected no match for lodash, got %#v", got)
+	}
+}
+
+func TestRootDirCandidates(t *testing.T) {
+	rootDirs := []string{"web/src", "web/generated", "web/src/vendor"}
+	for _, tc := range []struct {
+		desc, path string
+		want       []string
+	}{
+		{
+			desc: "file in a root",
+			path: "web/src/api/client",
+			want: []string{"web/generated/api/client", "web/src/vendor/api/client"},
+		},
+		{
+			desc: "innermost root",
+			path: "web/src/vendor/lib",
+			want: []string{"web/src/lib", "web/generated/lib"},
+		},
+		{
+			desc: "outside of the roots",
+			path: "web/other/client",
+			want: nil,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			got := rootDirCandidates(rootDirs, tc.path)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Inequalith.\ngot  %#v;\nwant %#v", got, tc.want)
+			}
+		})
+	}
+}